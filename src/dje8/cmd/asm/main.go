@@ -3,36 +3,22 @@ package main
 import (
 	"flag"
 	"fmt"
-	"io/fs"
 	"os"
-	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"unicode"
 
-	"damien.live/dje8/pkg/common"
+	"damien.live/dje8/pkg/asm"
+	"damien.live/dje8/pkg/ucodebuilder"
 )
 
-type asmToken struct {
-	value   byte
-	pointer string
-	lineNo  uint16
-}
-
-type field struct {
-	content string
-	lineNo  uint16
-}
-
-var tokens []asmToken
-var fields []field
-var currentAddress uint16 = 0
-var labelMap map[string]uint16 = make(map[string]uint16)
-
 var filename string
 var paddedSize int = 0
 var paddingByte ByteValue = 0x00
 var mode ModeValue = 'x'
+var writeSymbols bool = false
+var writeListing bool = false
 
 func main() {
 	flag.Parse() // parse args... only requirement is filename
@@ -40,140 +26,257 @@ func main() {
 		die("Error: filename required\n")
 	}
 
-	fileBytes, err := os.ReadFile(filename) // read file
+	file, err := os.Open(filename) // read file
 	if err != nil {
-		die(fmt.Sprintf("Problem reading file: %e\n", err))
-	}
-	for i, lineStr := range strings.Split(string(fileBytes), "\n") { // separate into lines
-		for fieldStr := range strings.FieldsSeq(strings.ReplaceAll(strings.Split(lineStr, ";")[0], ",", " ")) { // remove comments and commas
-			fields = append(fields, field{fieldStr, uint16(i + 1)}) // separate into fields
-		}
-	}
-	for i := 0; i < len(fields); i++ { // first pass process each field except label pointers; map label addresses
-		currentField := fields[i]
-		if strings.HasPrefix(currentField.content, "#") { // DIRECTIVE
-			if currentField.content == "#org" {
-				isStartingDirective := (i == 0)
-				i++
-				currentField = fields[i]
-				address, err := strconv.ParseUint(currentField.content, 0, 16)
-				if err != nil {
-					die(fmt.Sprintf("error parsing #org directive address (%s) on line %d: %e\n", currentField.content, currentField.lineNo, err))
-				}
-				if !isStartingDirective {
-					if uint16(address) < currentAddress {
-						die(fmt.Sprintf("invalid #org directive on line %d, would result in a negative offset\n", currentField.lineNo))
-					}
-					tokens = append(tokens, make([]asmToken, uint16(address)-currentAddress)...) // padding
-				}
-				currentAddress = uint16(address)
-			} else {
-				die(fmt.Sprintf("unknown assembler directive (%s) on line %d\n", currentField.content, currentField.lineNo))
-			}
-		} else if strings.HasSuffix(currentField.content, ":") { // LABEL
-			labelMap[currentField.content[:len(currentField.content)-1]] = currentAddress
-		} else if _, found := common.OpCodeLookup[currentField.content]; found { // INSTRUCTION
-			tokens = append(tokens, asmToken{byte(common.OpCodeLookup[currentField.content]), "", currentField.lineNo})
-			currentAddress++
-		} else if isData(currentField.content) {
-			if strings.HasPrefix(currentField.content, "'") {
-				tokens = append(tokens, asmToken{[]byte(currentField.content)[1], "", currentField.lineNo})
-				currentAddress++
-			} else {
-				isTwoBytes := (strings.HasPrefix(currentField.content, "0x") && len(currentField.content) > 4)
-				parsed, err := strconv.ParseUint(currentField.content, 0, 16)
-				if err != nil {
-					die(fmt.Sprintf("error parsing data (%s) at line %d: %e\n", currentField.content, currentField.lineNo, err))
-				}
-				isTwoBytes = isTwoBytes || parsed > 255
-				tokens = append(tokens, asmToken{byte(parsed), "", currentField.lineNo}) // one byte or LSB
-				currentAddress++
-				if isTwoBytes {
-					tokens = append(tokens, asmToken{byte(parsed >> 8), "", currentField.lineNo}) // MSB
-					currentAddress++
-				}
-			}
-		} else { // must be pointer
-			if strings.ContainsAny(currentField.content, "<>") {
-				tokens = append(tokens, asmToken{0, currentField.content, currentField.lineNo})
-				currentAddress++
-			} else {
-				tokens = append(tokens, []asmToken{{0, "<" + currentField.content, currentField.lineNo}, {0, ">" + currentField.content, currentField.lineNo}}...)
-				currentAddress += 2
-			}
+		die(fmt.Sprintf("Problem reading file: %v\n", err))
+	}
+	program, diagnostics := asm.Assemble(file, asm.Options{Filename: filename})
+	file.Close()
+	for _, diagnostic := range diagnostics {
+		fmt.Fprintln(os.Stderr, diagnostic)
+	}
+	if len(diagnostics) > 0 {
+		die(fmt.Sprintf("%d error(s), nothing written", len(diagnostics)))
+	}
+
+	if mode == 'x' {
+		printHex(program.Bytes)
+		for _, bank := range program.Banks {
+			fmt.Printf("bank %d\n", bank.Bank)
+			printHex(bank.Bytes)
 		}
 	}
 
-	for i := range len(tokens) { // second pass, replace label pointers with addresses of labels
-		pointer := tokens[i].pointer
-		if pointer != "" {
-			offsetIdx := strings.LastIndexAny(pointer, "+-")
-			isMSB := strings.HasPrefix(pointer, ">")
-			var offset int64
-			var err error
-			if offsetIdx > 0 {
-				offset, err = strconv.ParseInt(pointer[offsetIdx:], 0, 16)
-				if err != nil {
-					die(fmt.Sprintf("error parsing pointer (%s) at line %d: %e\n", tokens[i].pointer, tokens[i].lineNo, err))
-				}
-				pointer = pointer[:offsetIdx]
-			}
-			address, found := labelMap[pointer[1:]]
-			if !found {
-				die(fmt.Sprintf("error parsing pointer (%s) at line %d: %e\n", pointer[1:], tokens[i].lineNo, err))
-			}
-			address += uint16(offset)
-			if isMSB {
-				tokens[i].value = byte(address >> 8)
-			} else {
-				tokens[i].value = byte(address)
+	if mode == 'b' {
+		writeBinary(strings.Join([]string{filename, ".bin"}, ""), program.Bytes)
+		for _, bank := range program.Banks {
+			writeBinary(fmt.Sprintf("%s.bank%d.bin", filename, bank.Bank), bank.Bytes)
+		}
+	}
+
+	if mode == 'a' {
+		for _, segment := range program.Segments() {
+			writeFile(fmt.Sprintf("%s.%04x.bin", filename, segment.Address), segment.Bytes)
+		}
+		for _, bank := range program.Banks {
+			for _, segment := range bank.Segments() {
+				writeFile(fmt.Sprintf("%s.bank%d.%04x.bin", filename, bank.Bank, segment.Address), segment.Bytes)
 			}
 		}
 	}
 
-	if mode == 'x' {
-		chars := ""
-		for i, token := range tokens {
-			if i%16 == 0 {
-				fmt.Printf("%08x ", i)
+	if mode == 'i' {
+		writeFile(strings.Join([]string{filename, ".hex"}, ""), []byte(formatIntelHex(program.Segments())))
+		for _, bank := range program.Banks {
+			writeFile(fmt.Sprintf("%s.bank%d.hex", filename, bank.Bank), []byte(formatIntelHex(bank.Segments())))
+		}
+	}
+
+	if mode == 's' {
+		writeFile(strings.Join([]string{filename, ".s19"}, ""), []byte(formatSRecord(filename, program.Segments(), program.Origin)))
+		for _, bank := range program.Banks {
+			name := fmt.Sprintf("%s.bank%d", filename, bank.Bank)
+			writeFile(name+".s19", []byte(formatSRecord(name, bank.Segments(), bank.Origin)))
+		}
+	}
+
+	if writeSymbols {
+		writeFile(strings.Join([]string{filename, ".sym"}, ""), []byte(formatSymbols(program.Symbols)))
+	}
+
+	if writeListing {
+		writeFile(strings.Join([]string{filename, ".lst"}, ""), []byte(formatListing(program.Listing)))
+	}
+}
+
+// printHex prints bytes to the console in a format similar to hexdump
+func printHex(bytes []byte) {
+	chars := ""
+	for i, value := range bytes {
+		if i%16 == 0 {
+			fmt.Printf("%08x ", i)
+		}
+		fmt.Printf(" %02x", value)
+		if unicode.IsPrint(rune(value)) {
+			chars = chars + string(value)
+		} else {
+			chars = chars + "."
+		}
+		if (i+1)%8 == 0 {
+			fmt.Print(" ")
+		}
+		if (i+1)%16 == 0 || i == len(bytes)-1 {
+			fmt.Printf(" \033[61G|%s|\n", chars)
+			chars = ""
+		}
+	}
+	fmt.Printf("%08x\n", len(bytes))
+}
+
+// writeBinary writes bytes to a binary file, padded per the -s and -p flags
+func writeBinary(name string, bytes []byte) {
+	if len(bytes) < paddedSize {
+		for i := len(bytes); i < paddedSize; i++ {
+			bytes = append(bytes, byte(paddingByte))
+		}
+	}
+	writeFile(name, bytes)
+}
+
+func writeFile(name string, contents []byte) {
+	if err := os.WriteFile(name, contents, 0644); err != nil {
+		die(fmt.Sprintf("Problem writing file: %v", err))
+	}
+}
+
+// formatIntelHex renders segments as Intel HEX data records of up to 16
+// bytes, ending with the end of file record
+func formatIntelHex(segments []asm.Segment) string {
+	var retval strings.Builder
+	for _, segment := range segments {
+		for i := 0; i < len(segment.Bytes); i += 16 {
+			data := segment.Bytes[i:min(len(segment.Bytes), i+16)]
+			address := segment.Address + uint16(i)
+			record := append([]byte{byte(len(data)), byte(address >> 8), byte(address), 0x00}, data...)
+			fmt.Fprintf(&retval, ":%X%02X\n", record, -sum(record))
+		}
+	}
+	retval.WriteString(":00000001FF\n")
+	return retval.String()
+}
+
+// formatSRecord renders segments as Motorola S-records: a header naming the
+// file (cut to the 252 bytes a record holds), S1 data records of up to 16
+// bytes, a count of them and an S9 record giving the address to start at
+func formatSRecord(name string, segments []asm.Segment, start uint16) string {
+	var retval strings.Builder
+	writeRecord := func(recordType byte, address uint16, data []byte) {
+		record := append([]byte{byte(len(data) + 3), byte(address >> 8), byte(address)}, data...)
+		fmt.Fprintf(&retval, "S%d%X%02X\n", recordType, record, ^sum(record))
+	}
+	header := []byte(name)
+	writeRecord(0, 0, header[:min(len(header), 252)])
+	count := 0
+	for _, segment := range segments {
+		for i := 0; i < len(segment.Bytes); i += 16 {
+			writeRecord(1, segment.Address+uint16(i), segment.Bytes[i:min(len(segment.Bytes), i+16)])
+			count++
+		}
+	}
+	if count <= 0xffff {
+		writeRecord(5, uint16(count), nil)
+	}
+	writeRecord(9, start, nil)
+	return retval.String()
+}
+
+func sum(bytes []byte) byte {
+	var total byte
+	for _, value := range bytes {
+		total += value
+	}
+	return total
+}
+
+// formatSymbols renders the label map one "label 0xADDR" pair per line,
+// ordered by address, for consumption by the emulator's debugger
+func formatSymbols(labels map[string]uint16) string {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if labels[names[i]] != labels[names[j]] {
+			return labels[names[i]] < labels[names[j]]
+		}
+		return names[i] < names[j]
+	})
+	var retval strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&retval, "%s 0x%04x\n", name, labels[name])
+	}
+	return retval.String()
+}
+
+// formatListing renders each line of the source, and of each macro
+// expansion (marked with a + per level), next to its address, its bytes and
+// the clock cycles its instructions take according to the microcode, then
+// the symbol table and the cross-reference
+func formatListing(listing *asm.Listing) string {
+	const bytesPerRow = 4
+	ucode := ucodebuilder.BuildUcode()
+	var retval strings.Builder
+	fmt.Fprintf(&retval, "%-7s  %-11s  %5s  %5s  %s\n", "ADDRESS", "BYTES", "CLOCK", "LINE", "SOURCE")
+	file := ""
+	for _, line := range listing.Lines {
+		if line.File != file && line.Depth == 0 {
+			fmt.Fprintf(&retval, "\n; %s\n", line.File)
+			file = line.File
+		}
+		address := ""
+		if line.Assembled || len(line.Bytes) > 0 {
+			address = fmt.Sprintf("%04x", line.Address)
+			if line.Bank >= 0 {
+				address = fmt.Sprintf("%d:%04x", line.Bank, line.Address)
 			}
-			fmt.Printf(" %02x", token.value)
-			if unicode.IsPrint(rune(token.value)) {
-				chars = chars + string(token.value)
-			} else {
-				chars = chars + "."
+		}
+		clocks := ""
+		if len(line.OpCodes) > 0 {
+			fewest, most := 0, 0
+			for _, op := range line.OpCodes {
+				opFewest, opMost := ucodebuilder.Cycles(ucode, op)
+				fewest, most = fewest+opFewest, most+opMost
 			}
-			if (i+1)%8 == 0 {
-				fmt.Print(" ")
+			clocks = strconv.Itoa(fewest)
+			if most != fewest {
+				clocks = fmt.Sprintf("%d-%d", fewest, most)
 			}
-			if (i+1)%16 == 0 || i == len(tokens)-1 {
-				fmt.Printf(" \033[61G|%s|\n", chars)
-				chars = ""
+		}
+		bytes := line.Bytes
+		row := bytes[:min(len(bytes), bytesPerRow)]
+		fmt.Fprintf(&retval, "%-7s  %-11s  %5s  %5d  %s%s\n", address, formatBytes(row), clocks, line.Line, strings.Repeat("+", line.Depth), line.Text)
+		for i := bytesPerRow; i < len(bytes); i += bytesPerRow { // the rest of the bytes, a row at a time
+			address = fmt.Sprintf("%04x", line.Address+uint16(i))
+			if line.Bank >= 0 {
+				address = fmt.Sprintf("%d:%04x", line.Bank, line.Address+uint16(i))
 			}
+			fmt.Fprintf(&retval, "%-7s  %s\n", address, formatBytes(bytes[i:min(len(bytes), i+bytesPerRow)]))
 		}
-		fmt.Printf("%08x\n", len(tokens))
 	}
 
-	if mode == 'b' {
-		var bytes []byte
-		for _, token := range tokens {
-			bytes = append(bytes, token.value)
+	fmt.Fprintf(&retval, "\nSYMBOLS\n")
+	for _, symbol := range listing.Symbols {
+		kind := "label"
+		if symbol.Constant {
+			kind = "#equ"
 		}
-		if len(bytes) < paddedSize {
-			for i := len(bytes); i < paddedSize; i++ {
-				bytes = append(bytes, byte(paddingByte))
-			}
+		value := fmt.Sprintf("0x%04x", symbol.Value)
+		if symbol.Value < 0 {
+			value = strconv.FormatInt(symbol.Value, 10)
 		}
-		os.WriteFile(strings.Join([]string{filename, ".bin"}, ""), bytes, fs.ModePerm)
+		fmt.Fprintf(&retval, "%-24s %-7s %-5s %s:%d\n", symbol.Name, value, kind, symbol.Defined.File, symbol.Defined.Line)
 	}
+
+	fmt.Fprintf(&retval, "\nCROSS-REFERENCE\n")
+	for _, symbol := range listing.Symbols {
+		fmt.Fprintf(&retval, "%-24s", symbol.Name)
+		if len(symbol.References) == 0 {
+			retval.WriteString(" unused")
+		}
+		for _, reference := range symbol.References {
+			fmt.Fprintf(&retval, " %s:%d", reference.File, reference.Line)
+		}
+		retval.WriteString("\n")
+	}
+	return retval.String()
 }
 
-func isData(field string) bool {
-	matched, err := regexp.MatchString("^(('.')|([+-]?(0|[1-9][0-9]*))|(0[0-7]*)|(0x[0-9a-fA-F]*))$", field)
-	if err != nil {
-		die(fmt.Sprintf("regular expression to match numerics is in error: %e", err))
+func formatBytes(bytes []byte) string {
+	hex := make([]string, len(bytes))
+	for i, value := range bytes {
+		hex[i] = fmt.Sprintf("%02x", value)
 	}
-	return matched
+	return strings.Join(hex, " ")
 }
 
 func die(message string) {
@@ -188,16 +291,24 @@ type ModeValue rune
 func init() {
 	const (
 		modeUsage = "x - output bytes to the console in a format similar to hexdump\n" +
-			"b - output bytes in a binary file"
+			"b - output bytes in a binary file\n" +
+			"a - output each run of bytes between #org gaps in its own binary file, named with its address (<filename>.<ADDR>.bin)\n" +
+			"i - output bytes with their addresses in an Intel HEX file (<filename>.hex)\n" +
+			"s - output bytes with their addresses in a Motorola S-record file (<filename>.s19)"
 		paddingByteUsage = "byte to use as padding if outputting binary file"
 		paddedSizeUsage  = "size in bytes to pad if outputting binary file\n" +
 			"will not be padded if the size is smaller than the number of bytes generated"
 		filenameUsage = "required: the name of the file containing the code to be assembled"
+		symbolsUsage  = "also write the label addresses to a symbol file (<filename>.sym)"
+		listingUsage  = "also write a listing of each line with its address, bytes and clock cycles,\n" +
+			"followed by the symbols and where they are used (<filename>.lst)"
 	)
 	flag.Var(&mode, "m", modeUsage)
 	flag.Var(&paddingByte, "p", paddingByteUsage)
 	flag.IntVar(&paddedSize, "s", 0, paddedSizeUsage)
 	flag.StringVar(&filename, "f", "", filenameUsage)
+	flag.BoolVar(&writeSymbols, "l", false, symbolsUsage)
+	flag.BoolVar(&writeListing, "list", false, listingUsage)
 }
 
 func (v *ByteValue) String() string {
@@ -224,6 +335,10 @@ func (v *ModeValue) Set(s string) error {
 		*v = 'b'
 	} else if strings.HasPrefix(s, "A") || strings.HasPrefix(s, "a") {
 		*v = 'a'
+	} else if strings.HasPrefix(s, "I") || strings.HasPrefix(s, "i") {
+		*v = 'i'
+	} else if strings.HasPrefix(s, "S") || strings.HasPrefix(s, "s") {
+		*v = 's'
 	} else {
 		return fmt.Errorf("cannot process %s into mode", s)
 	}
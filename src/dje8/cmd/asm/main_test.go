@@ -0,0 +1,61 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"damien.live/dje8/pkg/asm"
+)
+
+// TestFormatListing checks the listing, and the clocks each line takes by
+// the microcode: a branch taken or not, and two instructions on a line
+func TestFormatListing(t *testing.T) {
+	program, diagnostics := asm.Assemble(strings.NewReader("#equ COUNT 2\n#org 0x8000\nstart: LODI COUNT\nBNE start\nLODZ 0x10 ADDA 0x9000\n#byte 1 2 3 4 5\n"), asm.Options{Filename: "test.asm"})
+	if len(diagnostics) > 0 {
+		t.Fatal(diagnostics)
+	}
+	want := `ADDRESS  BYTES        CLOCK   LINE  SOURCE
+
+; test.asm
+                                 1  #equ COUNT 2
+8000                             2  #org 0x8000
+8000     04 02            4      3  start: LODI COUNT
+8002     31 fd          3-4      4  BNE start
+8004     06 10 11 00     18      5  LODZ 0x10 ADDA 0x9000
+8008     90
+8009     01 02 03 04             6  #byte 1 2 3 4 5
+800d     05
+
+SYMBOLS
+COUNT                    0x0002  #equ  test.asm:1
+start                    0x8000  label test.asm:3
+
+CROSS-REFERENCE
+COUNT                    test.asm:3
+start                    test.asm:4
+`
+	if got := formatListing(program.Listing); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+var segments = []asm.Segment{{Address: 0x8000, Bytes: []byte("0123456789abcdefXY")}, {Address: 0x9000, Bytes: []byte{0xff}}}
+
+func TestFormatIntelHex(t *testing.T) {
+	want := ":10800000303132333435363738396162636465660E\n:028010005859BD\n:01900000FF70\n:00000001FF\n"
+	if got := formatIntelHex(segments); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestFormatSRecord(t *testing.T) {
+	want := "S00B0000746573742E61736DC5\nS1138000303132333435363738396162636465660A\nS10580105859B9\nS1049000FF6C\nS5030003F9\nS90380007C\n"
+	if got := formatSRecord("test.asm", segments, 0x8000); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+	// the header is cut to the 252 bytes a record can hold
+	want = "S0FF0000" + strings.Repeat("78", 252) + "E0\nS5030000FC\nS90380007C\n"
+	if got := formatSRecord(strings.Repeat("x", 300), nil, 0x8000); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
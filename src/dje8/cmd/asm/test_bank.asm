@@ -0,0 +1,26 @@
+; Places code and data in two banks of a window at 0x4000, then halts with
+; 0x22 0x11 at result.
+; Assemble with -m b to get test_bank.asm.bin plus one .bankN.bin per bank, and run with:
+; emu -bank 0x4000-0x7fff:4 -f test_bank.asm.bin -o 0x8000
+;     -bankfile test_bank.asm.bank1.bin@0x4000:1 -bankfile test_bank.asm.bank2.bin@0x4000:2
+#org 0x8000
+start:  LODI 0x02       ;
+        STOA 0xf040     ; show bank 2 in window 0
+        LODA marker2    ;
+        STOA result     ;
+        LODI 0x01       ;
+        STOA 0xf040     ; show bank 1 in window 0
+        JMP banked      ;
+back:   STOA result+1   ;
+        HALT            ;
+result: 0x00 0x00       ;
+
+#bank 1
+#org 0x4000
+banked: LODA marker1    ;
+        JMP back        ;
+marker1: 0x11           ;
+
+#bank 2
+#org 0x4000
+marker2: 0x22           ;
@@ -0,0 +1,21 @@
+; Copies a message with the DMA controller, then halts.
+; Run with: emu -dma -f test_dma.asm.bin -o 0x8000 -d 0x8100-0x810f
+#org 0x8000
+start:  LODI <message   ; source
+        STOA 0xf400     ;
+        LODI >message   ;
+        STOA 0xf401     ;
+        LODI 0x00       ; destination 0x8100
+        STOA 0xf402     ;
+        LODI 0x81       ;
+        STOA 0xf403     ;
+        LODI 0x0c       ; 12 bytes
+        STOA 0xf404     ;
+        LODI 0x00       ;
+        STOA 0xf405     ;
+        LODI 0x01       ; start; the CPU gives up the bus after this instruction
+        STOA 0xf406     ;
+        LODA 0xf407     ; status reads DMADone once the copy has finished
+        HALT            ;
+
+message: #byte "Hello world!"
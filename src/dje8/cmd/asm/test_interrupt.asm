@@ -0,0 +1,34 @@
+; Counts interval timer interrupts until there have been five, then halts.
+; Run with: emu -timer -f test_interrupt.asm.bin -o 0x8000
+#include "dje8.inc"
+#org 0x8000
+start:  LODI <tick      ; point the interrupt vector at the handler
+        STOA IRQ_VECTOR ;
+        LODI >tick      ;
+        STOA IRQ_VECTOR+1
+        LODI <1000      ; interrupt every 1000 clocks
+        STOA TIMER_RELOAD_LO
+        LODI >1000      ;
+        STOA TIMER_RELOAD_HI
+        LODI TIMER_ENABLE|TIMER_IRQ
+        STOA TIMER_CONTROL
+        CLI             ;
+wait:   LODA count      ;
+        SUBA limit      ;
+        BEQ done        ;
+        JMP wait        ;
+done:   SEI             ;
+        HALT            ;
+
+tick:   PUSH            ; save A
+        LODI TIMER_EXPIRED
+        STOA TIMER_STATUS ; acknowledge the timer
+        LODA count      ;
+        ADDA one        ;
+        STOA count      ;
+        POP             ; restore A
+        RTI             ; restores the flags of the interrupted code
+
+count:  0x00            ;
+limit:  0x05            ;
+one:    0x01            ;
@@ -0,0 +1,28 @@
+; Reads three keys into text, with the scan code of the first in scan, then halts.
+; Run with: emu -keyboard test_keyboard.keys -f test_keyboard.asm.bin -o 0x8000
+#org 0x8000
+wait1:  LODA 0xf021     ; keyboard status
+        SUBA one        ; a key is waiting
+        BEQ key1        ;
+        JMP wait1       ;
+key1:   LODA 0xf023     ; scan code, left in the FIFO
+        STOA scan       ;
+        LODA 0xf020     ; ASCII, taken from the FIFO
+        STOA text       ;
+wait2:  LODA 0xf021     ;
+        SUBA one        ;
+        BEQ key2        ;
+        JMP wait2       ;
+key2:   LODA 0xf020     ;
+        STOA text+1     ;
+wait3:  LODA 0xf021     ;
+        SUBA one        ;
+        BEQ key3        ;
+        JMP wait3       ;
+key3:   LODA 0xf020     ;
+        STOA text+2     ;
+        HALT            ;
+
+one:    0x01            ;
+scan:   0x00            ;
+text:   0x00 0x00 0x00  ;
@@ -0,0 +1,21 @@
+; Writes a greeting in the top left corner of the screen, puts the cursor
+; after it and halts.
+; Run with: emu -video -f test_video.asm.bin -o 0x8000
+#org 0x8000
+start:  LODI 'H'        ; characters and attributes alternate in the buffer
+        STOA 0xb000     ;
+        LODI 0xe0       ; red
+        STOA 0xb001     ;
+        LODI 'i'        ;
+        STOA 0xb002     ;
+        LODI 0x1c       ; green
+        STOA 0xb003     ;
+        LODI '!'        ;
+        STOA 0xb004     ;
+        LODI 0xff       ; white
+        STOA 0xb005     ;
+        LODI 0x03       ; cursor on row 0, column 3
+        STOA 0xfc01     ;
+        LODI 0x03       ; display and cursor enabled
+        STOA 0xfc00     ;
+        HALT            ;
@@ -1,20 +1,50 @@
 package main
 
 import (
+	"flag"
 	"fmt"
+	"hash/crc32"
+	"math/bits"
+	"os"
+	"strconv"
+	"strings"
 
 	//lint:ignore ST1001 importing common shared across all dje8 cmds
 	. "damien.live/dje8/pkg/common"
 	"damien.live/dje8/pkg/ucodebuilder"
 )
 
+// ControlBytes is the number of 8-bit EEPROMs a Control Word is split across
+const ControlBytes = 4
+
+// AddressLines is the width of the Control ROM address: flags, opcode, step
+const AddressLines = 16
+
+var prefix string
+var eepromSize SizeValue = 1 << AddressLines
+var lines LinesValue = identityLines()
+
 func main() {
+	flag.Parse()
+
+	Ucode := ucodebuilder.BuildUcode()
+	if problems := ucodebuilder.Verify(Ucode); len(problems) > 0 {
+		for _, problem := range problems {
+			fmt.Fprintln(os.Stderr, problem)
+		}
+		fmt.Fprintf(os.Stderr, "%d problem(s) in the microcode\n", len(problems))
+		os.Exit(1)
+	}
+
+	if prefix != "" {
+		writeImages(Ucode)
+		return
+	}
+
 	fmt.Println("***** DJE-8 ControlROM Builder *****")
 	fmt.Println()
 	DebugPrintConsts()
 
-	Ucode := ucodebuilder.BuildUcode()
-
 	for i, ControlWord := range Ucode {
 		if i%16 == 0 {
 			fmt.Printf("\n%04x: ", i)
@@ -31,3 +61,190 @@ func main() {
 
 	fmt.Println()
 }
+
+// writeImages writes a binary and an Intel HEX image for every EEPROM and a
+// manifest describing them.  Each byte of the Control Word gets its own
+// EEPROMs; when an EEPROM is smaller than the Control ROM the image is
+// split across several, the top address lines selecting between them.
+func writeImages(Ucode []Control) {
+	parts := max(1, (1<<AddressLines)/int(eepromSize))
+	var manifest strings.Builder
+	fmt.Fprintf(&manifest, "DJE-8 Control ROM: %d byte(s) of the Control Word in %d EEPROM(s) of %d bytes each\n", ControlBytes, ControlBytes*parts, eepromSize)
+	fmt.Fprintf(&manifest, "Control ROM address: bits 15-12 flags (Z C N V), 11-4 opcode, 3-0 step\n")
+	for line := range min(AddressLines, bits.Len(uint(eepromSize))-1) {
+		fmt.Fprintf(&manifest, "EEPROM A%d = Control ROM address bit %d\n", line, lines[line])
+	}
+	if parts > 1 {
+		fmt.Fprintf(&manifest, "part number = Control ROM address bits %s, lowest first\n", formatLines(lines[bits.Len(uint(eepromSize))-1:]))
+	}
+	if eepromSize > 1<<AddressLines {
+		fmt.Fprintf(&manifest, "EEPROM A%d and up are tied low\n", AddressLines)
+	}
+	for controlByte := range ControlBytes {
+		var signals []string
+		for bit := 7; bit >= 0; bit-- {
+			signals = append(signals, (Control(1) << (8*controlByte + bit)).String())
+		}
+		fmt.Fprintf(&manifest, "byte%d D7-D0: %s\n", controlByte, strings.Join(signals, " "))
+	}
+	fmt.Fprintf(&manifest, "\n%-32s %8s %6s %10s\n", "FILE", "SIZE", "SUM16", "CRC32")
+
+	for controlByte := range ControlBytes {
+		for part := range parts {
+			name := fmt.Sprintf("%s.byte%d", prefix, controlByte)
+			if parts > 1 {
+				name = fmt.Sprintf("%s.part%d", name, part)
+			}
+			image, used := eepromImage(Ucode, controlByte, part)
+			writeFile(name+".bin", image)
+			writeFile(name+".hex", []byte(formatIntelHex(image[:used])))
+			var sum16 uint16
+			for _, value := range image {
+				sum16 += uint16(value)
+			}
+			fmt.Fprintf(&manifest, "%-32s %8d 0x%04x 0x%08x\n", name+".bin", len(image), sum16, crc32.ChecksumIEEE(image))
+		}
+	}
+	writeFile(prefix+".manifest", []byte(manifest.String()))
+	fmt.Print(manifest.String())
+}
+
+// eepromImage returns the contents of one EEPROM, holding controlByte of
+// the Control Words in part of the Control ROM, and how much of it is
+// used.  The unused top of an EEPROM larger than the Control ROM is left
+// erased (0xff).
+func eepromImage(Ucode []Control, controlByte int, part int) ([]byte, int) {
+	image := make([]byte, eepromSize)
+	used := min(int(eepromSize), 1<<AddressLines)
+	for offset := range image {
+		if offset >= used {
+			image[offset] = 0xff
+			continue
+		}
+		eepromAddress := part*used + offset
+		romAddress := 0
+		for line := range AddressLines {
+			romAddress |= (eepromAddress >> line & 1) << lines[line]
+		}
+		image[offset] = byte(Ucode[romAddress] >> (8 * controlByte))
+	}
+	return image, used
+}
+
+// formatIntelHex renders bytes from address 0 as Intel HEX data records of
+// up to 16 bytes, with extended linear address records past 64K
+func formatIntelHex(bytes []byte) string {
+	var retval strings.Builder
+	writeRecord := func(recordType byte, address uint16, data []byte) {
+		record := append([]byte{byte(len(data)), byte(address >> 8), byte(address), recordType}, data...)
+		fmt.Fprintf(&retval, ":%X%02X\n", record, -sum(record))
+	}
+	for i := 0; i < len(bytes); i += 16 {
+		if i > 0 && i%0x10000 == 0 {
+			writeRecord(0x04, 0, []byte{byte(i >> 24), byte(i >> 16)})
+		}
+		writeRecord(0x00, uint16(i), bytes[i:min(len(bytes), i+16)])
+	}
+	writeRecord(0x01, 0, nil)
+	return retval.String()
+}
+
+func sum(bytes []byte) byte {
+	var total byte
+	for _, value := range bytes {
+		total += value
+	}
+	return total
+}
+
+func writeFile(name string, contents []byte) {
+	if err := os.WriteFile(name, contents, 0644); err != nil {
+		die(fmt.Sprintf("Problem writing file: %v", err))
+	}
+}
+
+func die(message string) {
+	fmt.Fprintln(os.Stderr, message)
+	os.Exit(1)
+}
+
+// *** CLI FLag Stuff ***
+
+// SizeValue is an EEPROM size in bytes, a power of two
+type SizeValue int
+
+// LinesValue gives, for each EEPROM address line from A0 up, the Control
+// ROM address bit wired to it
+type LinesValue [AddressLines]int
+
+func identityLines() LinesValue {
+	var l LinesValue
+	for line := range l {
+		l[line] = line
+	}
+	return l
+}
+
+func formatLines(lines []int) string {
+	var s []string
+	for _, line := range lines {
+		s = append(s, strconv.Itoa(line))
+	}
+	return strings.Join(s, ",")
+}
+
+func init() {
+	const (
+		prefixUsage = "write the images to files starting with this prefix instead of printing the Control ROM:\n" +
+			"<prefix>.byteN.bin and <prefix>.byteN.hex for byte N (0 the least significant) of the Control Word,\n" +
+			"with .partP added when the Control ROM is split across several EEPROMs, and <prefix>.manifest\n" +
+			"listing the wiring and the checksum of every image"
+		sizeUsage = "EEPROM size in bytes, a power of two, e.g. 32768 for a 28C256\n" +
+			"the Control ROM is split across several EEPROMs if they are smaller than it"
+		linesUsage = "the Control ROM address bit wired to each EEPROM address line, from A0 up,\n" +
+			"e.g. 3,2,1,0,4,5,6,7,8,9,10,11,12,13,14,15 to reverse the step lines"
+	)
+	flag.StringVar(&prefix, "o", "", prefixUsage)
+	flag.Var(&eepromSize, "size", sizeUsage)
+	flag.Var(&lines, "lines", linesUsage)
+}
+
+func (v *SizeValue) String() string {
+	return strconv.Itoa(int(*v))
+}
+
+func (v *SizeValue) Set(s string) error {
+	size, err := strconv.ParseUint(s, 0, 32)
+	if err != nil {
+		return err
+	}
+	if size < 16 || size&(size-1) != 0 {
+		return fmt.Errorf("%s is not a power of two of at least 16", s)
+	}
+	*v = SizeValue(size)
+	return nil
+}
+
+func (v *LinesValue) String() string {
+	return formatLines(v[:])
+}
+
+func (v *LinesValue) Set(s string) error {
+	fields := strings.Split(s, ",")
+	if len(fields) != AddressLines {
+		return fmt.Errorf("%d address bits given, not %d", len(fields), AddressLines)
+	}
+	var seen [AddressLines]bool
+	for line, field := range fields {
+		bit, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil || bit < 0 || bit >= AddressLines {
+			return fmt.Errorf("%s is not a Control ROM address bit (0 to %d)", field, AddressLines-1)
+		}
+		if seen[bit] {
+			return fmt.Errorf("address bit %d is wired to more than one line", bit)
+		}
+		seen[bit] = true
+		v[line] = bit
+	}
+	return nil
+}
@@ -1,349 +1,423 @@
 package main
 
 import (
+	"encoding/hex"
+	"encoding/json"
+	"flag"
 	"fmt"
 	"os"
+	"os/signal"
+	"strconv"
 	"strings"
 	"time"
 
+	"damien.live/dje8/pkg/asm"
+	"damien.live/dje8/pkg/bus"
 	//lint:ignore ST1001 importing common shared across all dje8 cmds
 	. "damien.live/dje8/pkg/common"
+	"damien.live/dje8/pkg/cpu"
+	"damien.live/dje8/pkg/debugger"
+	"damien.live/dje8/pkg/devices"
 	"damien.live/dje8/pkg/ucodebuilder"
 )
 
 var Program = []byte{ // Pre ASM Code
 	byte(LODI), 0x01, // 0 LODI 1
 	byte(NOP),              // 1 OUT(NOP)
-	byte(ADDA), 0x00, 0x22, // 2 ADDA 34
-	byte(BCS), 0x00, 0x20, // 3 BCS 32??
+	byte(ADDA), 0x20, 0x00, // 2 ADDA 32
+	byte(BCS), 0x17, // 3 BCS 30 (offset 23 from 7)
 	byte(NOP),              // 4 OUT(NOP)
-	byte(STOA), 0x00, 0x21, // 5 STOA 33
-	byte(LODA), 0x00, 0x22, // 6 LODA 34
-	byte(ADDA), 0x00, 0x21, // 7 ADDA 33
-	byte(BCS), 0x00, 0x20, // 8 BCS 32??
+	byte(STOA), 0x1f, 0x00, // 5 STOA 31
+	byte(LODA), 0x20, 0x00, // 6 LODA 32
+	byte(ADDA), 0x1f, 0x00, // 7 ADDA 31
+	byte(BCS), 0x0b, // 8 BCS 30 (offset 11 from 19)
 	byte(NOP),              // 9 OUT(NOP)
-	byte(STOA), 0x00, 0x22, // a STOA 34
-	byte(LODA), 0x00, 0x21, // b LODA 33
-	byte(JMP), 0x00, 0x03, // c JMP 3
+	byte(STOA), 0x20, 0x00, // a STOA 32
+	byte(LODA), 0x1f, 0x00, // b LODA 31
+	byte(JMP), 0x03, 0x00, // c JMP 3
 	byte(HALT), // d HALT 0
 	0x01,       // e 1
 	0x01,       // f 1
 }
 
-// define registers
-var ProgramCounter uint16 = 0
-var MemoryAddressRegister uint16 = 0
-var StackPointer uint16 = 0
-var InstructionRegister uint8 = 0
-var AccumulatorRegister uint8 = 0
-var InternalRegister uint8 = 0
-var ArithmeticLogicUnit uint8 = 0
-var FlagsRegister Flag = 0
-var ControlWord Control = 0
-var ClockPulse uint8 = 0
-
-var AddressBus uint16 = 0
-var DataBus uint8 = 0xff // data bus is pulled high when inactive (can be used as a source of -1)
-var MemorySpace []byte
-var ROMAddress uint16
-var ControlROM []Control
-
 var EmulationHeaderPaddingSize int = 14
 
-func main() {
-	fmt.Println("***** DJE-8 Emulator *****")
-	fmt.Println()
-
-	DebugPrintConsts()
+var filename string
+var origin AddressValue = 0
+var entry AddressValue = 0
+var headless bool
+var cycleLimit uint64 = 0
+var jsonOut string
+var dumpRange RangeValue = RangeValue{0x0000, 0xffff}
+var debug bool
+var symbolFile string
+var uart1 string
+var uart2 string
+var timer bool
+var dma bool
+var video bool
+var keyboard string
+var symbols map[string]uint16    // labels of a program assembled in-process
+var restoreTerminal func() error // set while -keyboard stdio has the terminal in raw mode
+var Video *devices.Video         // set when -video attached a video controller
+var roms ROMValue
+var banks BankValue
+var bankImages BankImageValue
 
-	MemorySpace = make([]byte, 65536)
+func main() {
+	flag.Parse()
+
+	Decoder := bus.NewStandard()
+	mapBanks(Decoder)
+	for _, rom := range roms {
+		file, err := os.Open(rom.Filename)
+		if err != nil {
+			die(fmt.Sprintf("Problem reading ROM: %v", err))
+		}
+		image, err := bus.ReadROM(file)
+		file.Close()
+		if err != nil {
+			die(fmt.Sprintf("Problem reading ROM: %v", err))
+		}
+		if err := Decoder.MapROM(rom.Address, image, rom.Filename); err != nil {
+			die(err.Error())
+		}
+	}
+	CPU := cpu.New(ucodebuilder.BuildUcode(), Decoder)
+
+	attachUART(CPU, Decoder, uart1, Serial1Start, Serial1End)
+	attachUART(CPU, Decoder, uart2, Serial2Start, Serial2End)
+	if timer {
+		Timer := devices.NewTimer()
+		Decoder.Map(TimerStart, TimerEnd, "Timer", Timer)
+		CPU.AttachClock(Timer)
+		CPU.AttachIRQ(Timer)
+	}
+	attachKeyboard(CPU, Decoder, keyboard)
+	if video {
+		Video = devices.NewVideo()
+		Decoder.Map(VideoBufferStart, VideoBufferEnd, "Video Character Buffer", Video.Buffer)
+		Decoder.Map(VideoRegistersStart, VideoRegistersEnd, "Video Controller", Video.Registers())
+		EmulationHeaderPaddingSize += devices.VideoRows + 2
+	}
+	if dma {
+		DMA := devices.NewDMA()
+		Decoder.Map(Expansion1Start, Expansion1End, "DMA Controller", DMA)
+		CPU.AttachDMA(DMA)
+		CPU.AttachIRQ(DMA)
+	}
 
-	ControlROM = ucodebuilder.BuildUcode() // make([]Control, 65536)
+	if strings.TrimSpace(filename) == "" {
+		bus.Load(Decoder, 0, Program)
+	} else if strings.HasSuffix(filename, ".asm") {
+		program := assembleFile(Decoder, filename)
+		symbols = program.Symbols
+		if !isFlagSet("o") {
+			origin = AddressValue(program.Origin)
+		}
+	} else {
+		fileBytes, err := os.ReadFile(filename)
+		if err != nil {
+			die(fmt.Sprintf("Problem reading file: %v", err))
+		}
+		if err := bus.Load(Decoder, uint16(origin), fileBytes); err != nil {
+			die(fmt.Sprintf("Problem loading %s: %v", filename, err))
+		}
+	}
+	loadBankImages(Decoder)
+	CPU.ProgramCounter = uint16(origin)
+	if isFlagSet("e") {
+		CPU.ProgramCounter = uint16(entry)
+	}
 
-	// Test code
-	// r := rand.New(rand.NewSource(time.Now().Unix()))
-	// r.Read(MemorySpace) // Fill memory with 64K of randomness
-	// for i := range len(ControlROM) { // Fill control ROM with randomness (except for HLT and Reserved bits)
-	// 	ControlROM[i] = Control(r.Uint32()) &^ (HLT | EX3 | EX2 | EX1 | EX0)
-	// }
-	// End Test Code
+	if debug {
+		runDebugger(CPU)
+	} else if headless {
+		runHeadless(CPU)
+	} else {
+		runInteractive(CPU)
+	}
+	if restoreTerminal != nil {
+		restoreTerminal()
+	}
+}
 
-	fmt.Println("***** DJE-8 Simulation Starting *****")
-	copy(MemorySpace[0:], Program)
-	fmt.Println()
-	PrintEmulationHeaderPadding()
+// attachUART maps a UART at start..end backed by spec (see
+// devices.OpenSerialBackend) and connects it to CPU's IRQ line.  An empty
+// spec leaves the range unmapped.
+func attachUART(CPU *cpu.CPU, Decoder *bus.Decoder, spec string, start uint16, end uint16) {
+	if spec == "" {
+		return
+	}
+	if spec == "stdio" && debug {
+		die("a stdio serial port cannot share the terminal with the debugger")
+	}
+	backend, description, err := devices.OpenSerialBackend(spec)
+	if err != nil {
+		die(fmt.Sprintf("Problem opening serial port at 0x%04x: %v", start, err))
+	}
+	fmt.Fprintf(os.Stderr, "serial port at 0x%04x connected to %s\n", start, description)
+	UART := devices.NewUART(backend)
+	Decoder.Map(start, end, fmt.Sprintf("Serial Port (%s)", description), UART)
+	CPU.AttachIRQ(UART)
+}
 
-	// main Fetch-Decode-Execute loop
-	for {
-	InstructionCycle:
-		for ClockPulse = range 16 {
-			ControlWord = ControlROMLookup(ClockPulse)
-			// TODO: check for interrupts
-			// TODO: check bus arbiter
-			// AddressBus = 0
-			// DataBus = 0xff
-			PrintSnapshot()
-			fmt.Println()
-			time.Sleep(time.Millisecond * 1)
-			// fmt.Scanln()
-			switch ClockPulse {
-			case 0: // FETCH
-				AddressBus = ProgramCounter        // CO
-				MemoryAddressRegister = AddressBus // MI
-			case 1: // DECODE
-				DataBus = MemorySpace[MemoryAddressRegister] // RO
-				InstructionRegister = DataBus                // II
-				ProgramCounter++                             // CU
-			default: // EXECUTE
-				if ControlWord&HLT != 0 {
-					fmt.Println("*** HALT signal received. System halted.")
-					os.Exit(0)
-				}
-
-				// ***** OUT SIGNALS FIRST *****
-				// Address Bus OUT Signals
-				if ControlWord&COW != 0 {
-					AddressBus = ProgramCounter
-				}
-				if ControlWord&POW != 0 {
-					AddressBus = StackPointer
-				}
-				if ControlWord&ROW != 0 {
-					AddressBus = uint16(MemorySpace[MemoryAddressRegister])<<8 | uint16(MemorySpace[MemoryAddressRegister+1])
-				}
-
-				// Data Bus OUT Signals
-				if ControlWord&AO != 0 {
-					DataBus = AccumulatorRegister
-				}
-				if ControlWord&RO != 0 {
-					DataBus = MemorySpace[MemoryAddressRegister]
-				}
-				// *** ALU Start
-				var Mode ALUMode = ALUMode((ControlWord / AU0) & 0xf) // Getting just ALU flags in the lower half of one byte
-				switch Mode {
-				case ALUNOP:
-					// Any ALU mode other than NOP or CMP puts ALU contents on the data bus.
-					// Only arithmentic ALU modes and CMP load the ZCNV flags.
-					//
-					// This implementation of the emulator simply performs the arithmetic and updates the flags only
-					// on the cycles that the ALU bits are set even though the hardware implementation is likely
-					// always performing a computation.
-				case ALUADD:
-					DataBus = AddAndSetFlags(AccumulatorRegister, InternalRegister, false)
-				case ALUSUB:
-					DataBus = SubtractAndSetFlags(AccumulatorRegister, InternalRegister, false)
-				case ALUADC:
-					DataBus = AddAndSetFlags(AccumulatorRegister, InternalRegister, FlagsRegister&CarryFlagC != 0)
-				case ALUSBC:
-					DataBus = SubtractAndSetFlags(AccumulatorRegister, InternalRegister, FlagsRegister&CarryFlagC != 0)
-				case ALUAND:
-					DataBus = AccumulatorRegister & InternalRegister
-				case ALUOR:
-					DataBus = AccumulatorRegister | InternalRegister
-				case ALUNOT:
-					DataBus = ^AccumulatorRegister
-				case ALUNEG:
-					DataBus = -AccumulatorRegister
-					if DataBus == 0 {
-						FlagsRegister |= ZeroFlagZ
-					} else {
-						FlagsRegister &= (^ZeroFlagZ)
-					}
-					if DataBus&0x80 != 0 {
-						FlagsRegister |= NegativeFlagN
-					} else {
-						FlagsRegister &= (^NegativeFlagN)
-					}
-				case ALUINC:
-					DataBus = AccumulatorRegister + 1
-					if DataBus < AccumulatorRegister {
-						FlagsRegister |= CarryFlagC
-						FlagsRegister |= OverflowFlagV
-					} else {
-						FlagsRegister &= (^CarryFlagC)
-						FlagsRegister &= (^OverflowFlagV)
-					}
-					if DataBus == 0 {
-						FlagsRegister |= ZeroFlagZ
-					} else {
-						FlagsRegister &= (^ZeroFlagZ)
-					}
-					if DataBus&0x80 != 0 {
-						FlagsRegister |= NegativeFlagN
-					} else {
-						FlagsRegister &= (^NegativeFlagN)
-					}
-				case ALUDEC:
-					DataBus = AccumulatorRegister - 1
-					if DataBus > AccumulatorRegister {
-						FlagsRegister |= CarryFlagC
-						FlagsRegister |= OverflowFlagV
-					} else {
-						FlagsRegister &= (^CarryFlagC)
-						FlagsRegister &= (^OverflowFlagV)
-					}
-					if DataBus == 0 {
-						FlagsRegister |= ZeroFlagZ
-					} else {
-						FlagsRegister &= (^ZeroFlagZ)
-					}
-					if DataBus&0x80 != 0 {
-						FlagsRegister |= NegativeFlagN
-					} else {
-						FlagsRegister &= (^NegativeFlagN)
-					}
-				case ALUCMP:
-					_ = SubtractAndSetFlags(AccumulatorRegister, InternalRegister, false)
-				}
-				// *** ALU End
-
-				// ***** IN SIGNALS NEXT *****
-				// Address Bus IN Signals
-				if ControlWord&CIW != 0 {
-					ProgramCounter = AddressBus
-				}
-				if ControlWord&MIW != 0 {
-					MemoryAddressRegister = AddressBus
-				}
-				if ControlWord&RI != 0 {
-					MemorySpace[MemoryAddressRegister] = DataBus
-				}
-
-				// Data Bus IN Signals
-				if ControlWord&AI != 0 {
-					AccumulatorRegister = DataBus
-				}
-				if ControlWord&BI != 0 {
-					InternalRegister = DataBus
-				}
-				if ControlWord&CIH != 0 {
-					ProgramCounter = uint16(DataBus)<<8 | (ProgramCounter & 0x00ff)
-				}
-				if ControlWord&CIL != 0 {
-					ProgramCounter = uint16(DataBus) | (ProgramCounter & 0xff00)
-				}
-				// if ControlWord&MIH != 0 {
-				// 	MemoryAddressRegister = uint16(DataBus)<<8 | (MemoryAddressRegister & 0x00ff)
-				// }
-				// if ControlWord&MIL != 0 {
-				// 	MemoryAddressRegister = uint16(DataBus) | (MemoryAddressRegister & 0xff00)
-				// }
-				if ControlWord&II != 0 {
-					InstructionRegister = DataBus
-				}
-
-				// Increments and decrements
-				if ControlWord&CU != 0 {
-					ProgramCounter++
-				}
-				if ControlWord&CUW != 0 {
-					ProgramCounter += 2
-				}
-				if ControlWord&MU != 0 {
-					MemoryAddressRegister++
-				}
-				if ControlWord&MUW != 0 {
-					MemoryAddressRegister += 2
-				}
-				if ControlWord&PU != 0 {
-					StackPointer++
-				}
-				if ControlWord&PUW != 0 {
-					StackPointer += 2
-				}
-				if ControlWord&PD != 0 {
-					ProgramCounter--
-				}
-				if ControlWord&PDW != 0 {
-					ProgramCounter -= 2
-				}
-
-				// End instruction cycle last
-				if ControlWord&STR != 0 {
-					break InstructionCycle
-				}
+// mapBanks lays the -bank windows over memory and maps their bank select
+// registers, one per window in the order given
+func mapBanks(Decoder *bus.Decoder) {
+	if len(banks) == 0 {
+		return
+	}
+	if len(banks) > int(BankSelectEnd-BankSelectStart)+1 {
+		die(fmt.Sprintf("too many banked windows (%d), there are %d bank select registers", len(banks), BankSelectEnd-BankSelectStart+1))
+	}
+	Selects := bus.BankSelect{}
+	for _, window := range banks {
+		Banked := bus.NewBanked(window.Count, int(window.End-window.Start)+1)
+		Decoder.Map(window.Start, window.End, fmt.Sprintf("Banked Window %d (%d banks)", len(Selects), window.Count), Banked)
+		Selects = append(Selects, Banked)
+	}
+	Decoder.Map(BankSelectStart, BankSelectEnd, "Bank Select", Selects)
+}
 
-			}
+// loadBankImages copies the -bankfile images straight into their banks,
+// whichever bank is selected
+func loadBankImages(Decoder *bus.Decoder) {
+	for _, image := range bankImages {
+		fileBytes, err := os.ReadFile(image.Filename)
+		if err != nil {
+			die(fmt.Sprintf("Problem reading file: %v", err))
 		}
+		loadBank(Decoder, image.Filename, image.Address, image.Bank, fileBytes)
 	}
-
 }
 
-func ControlROMLookup(microStep uint8) Control {
-	// Control ROM Address calc:
-	// Z C N V OPCODEXX STEP
-	// where:
-	//	Z = zero flag
-	//	C = carry flag
-	//	N = negative flag
-	//  V = overflow flag
-	//  OPCODEXX = current 8-bit instruction op code
-	//  STEP = current 4-bit microcode step
+// loadBank copies data into bank of the banked window at address
+func loadBank(Decoder *bus.Decoder, name string, address uint16, bank int, data []byte) {
+	region, found := Decoder.RegionAt(address)
+	Banked, ok := region.Device.(*bus.Banked)
+	if !found || !ok {
+		die(fmt.Sprintf("Problem loading %s: 0x%04x is not in a banked window", name, address))
+	}
+	if bank >= len(Banked.Banks) {
+		die(fmt.Sprintf("Problem loading %s: the window at 0x%04x has no bank %d", name, region.Start, bank))
+	}
+	offset := int(address - region.Start)
+	if offset+len(data) > len(Banked.Banks[bank]) {
+		die(fmt.Sprintf("Problem loading %s: %d bytes do not fit in the window at 0x%04x", name, len(data), address))
+	}
+	copy(Banked.Banks[bank][offset:], data)
+}
 
-	ROMAddress = (uint16(InstructionRegister))<<4 | ((uint16(FlagsRegister))&0xf)<<12 | (uint16(microStep))
-	return Control(ControlROM[ROMAddress])
+// assembleFile assembles the source in filename, loads it and its banks
+// and returns its symbols
+func assembleFile(Decoder *bus.Decoder, filename string) *asm.Program {
+	file, err := os.Open(filename)
+	if err != nil {
+		die(fmt.Sprintf("Problem reading file: %v", err))
+	}
+	defer file.Close()
+	program, diagnostics := asm.Assemble(file, asm.Options{Filename: filename})
+	if len(diagnostics) > 0 {
+		for _, diagnostic := range diagnostics {
+			fmt.Fprintln(os.Stderr, diagnostic)
+		}
+		die(fmt.Sprintf("%d error(s) assembling %s", len(diagnostics), filename))
+	}
+	if err := bus.Load(Decoder, program.Origin, program.Bytes); err != nil {
+		die(fmt.Sprintf("Problem loading %s: %v", filename, err))
+	}
+	for _, bank := range program.Banks {
+		loadBank(Decoder, fmt.Sprintf("%s bank %d", filename, bank.Bank), bank.Origin, bank.Bank, bank.Bytes)
+	}
+	return program
 }
 
-func AddAndSetFlags(op1 uint8, op2 uint8, carryIn bool) uint8 {
-	var result uint16 = uint16(op1) + uint16(op2)
-	if carryIn {
-		result++
+// attachKeyboard maps the keyboard interface fed from source, either stdio
+// for the host terminal (switched to raw mode) or a keystroke script file,
+// and connects it to CPU's IRQ line.  An empty source leaves the range
+// unmapped.
+func attachKeyboard(CPU *cpu.CPU, Decoder *bus.Decoder, source string) {
+	if source == "" {
+		return
 	}
-	// if the 9th bit of the result before truncation isn't 0, we carried.
-	if result&0x0100 != 0 {
-		FlagsRegister |= CarryFlagC
+	var Keyboard *devices.Keyboard
+	if source == "stdio" {
+		if uart1 == "stdio" || uart2 == "stdio" || debug {
+			die("the keyboard cannot share the terminal with a stdio serial port or the debugger")
+		}
+		if restore, err := devices.RawTerminal(os.Stdin); err == nil {
+			restoreTerminal = restore
+			interrupts := make(chan os.Signal, 1)
+			signal.Notify(interrupts, os.Interrupt)
+			go func() {
+				<-interrupts
+				die("interrupted")
+			}()
+		}
+		Keyboard = devices.NewKeyboard(os.Stdin)
 	} else {
-		FlagsRegister &= (^CarryFlagC)
+		script, err := os.ReadFile(source)
+		if err != nil {
+			die(fmt.Sprintf("Problem reading keystroke file: %v", err))
+		}
+		Keyboard = devices.NewScriptedKeyboard(script)
 	}
-	// the the MSb of the operands is the same and the MSb of the result is different, we overflowed the sign bit
-	if AccumulatorRegister&0x80 == InternalRegister&0x80 && AccumulatorRegister&0x80 != DataBus&0x80 {
-		FlagsRegister |= OverflowFlagV
-	} else {
-		FlagsRegister &= (^OverflowFlagV)
+	Decoder.Map(KeyboardStart, KeyboardEnd, "Keyboard", Keyboard)
+	CPU.AttachIRQ(Keyboard)
+}
+
+// runInteractive redraws the machine state on every microstep
+func runInteractive(CPU *cpu.CPU) {
+	fmt.Println("***** DJE-8 Emulator *****")
+	fmt.Println()
+
+	DebugPrintConsts()
+
+	fmt.Println("***** DJE-8 Simulation Starting *****")
+	fmt.Println()
+	PrintEmulationHeaderPadding()
+
+	CPU.BeforeStep = func(c *cpu.CPU) {
+		PrintSnapshot(c)
+		fmt.Println()
+		time.Sleep(time.Millisecond * 1)
+		// fmt.Scanln()
 	}
-	if result == 0 {
-		FlagsRegister |= ZeroFlagZ
+	runToLimit(CPU)
+	if CPU.Halted {
+		fmt.Println("*** HALT signal received. System halted.")
 	} else {
-		FlagsRegister &= (^ZeroFlagZ)
+		fmt.Printf("*** Cycle limit (%d) reached. System stopped.\n", cycleLimit)
 	}
-	if result&0x80 != 0 {
-		FlagsRegister |= NegativeFlagN
+}
+
+// runHeadless runs with no display and no delay, then reports the final
+// state.  The exit status is 0 if the program halted and 2 if it hit the
+// cycle limit first.
+func runHeadless(CPU *cpu.CPU) {
+	runToLimit(CPU)
+
+	if jsonOut != "" {
+		report := Report{
+			Snapshot: CPU.Snapshot(),
+			Memory: MemoryDump{
+				Start: dumpRange.Start,
+				End:   dumpRange.End,
+				Bytes: hex.EncodeToString(peekRange(CPU.Bus, dumpRange.Start, dumpRange.End)),
+			},
+		}
+		if Video != nil {
+			report.Screen = Video.Text()
+		}
+		out, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			die(fmt.Sprintf("Problem encoding state: %v", err))
+		}
+		out = append(out, '\n')
+		if jsonOut == "-" {
+			os.Stdout.Write(out)
+		} else if err := os.WriteFile(jsonOut, out, 0644); err != nil {
+			die(fmt.Sprintf("Problem writing file: %v", err))
+		}
 	} else {
-		FlagsRegister &= (^NegativeFlagN)
+		PrintRegisters(CPU)
+		PrintMemory(CPU, dumpRange.Start, dumpRange.End)
+		if Video != nil {
+			PrintScreen()
+		}
 	}
 
-	return uint8(result)
+	if !CPU.Halted {
+		fmt.Fprintf(os.Stderr, "cycle limit (%d) reached before HALT\n", cycleLimit)
+		if restoreTerminal != nil {
+			restoreTerminal()
+		}
+		os.Exit(2)
+	}
 }
 
-func SubtractAndSetFlags(op1 uint8, op2 uint8, carryIn bool) uint8 {
-	var result uint8 = op1 - op2
-	if carryIn {
-		result--
+// runDebugger hands the CPU to the interactive debugger on the terminal.
+// Ctrl-C interrupts a running continue.
+func runDebugger(CPU *cpu.CPU) {
+	Debugger := debugger.New(CPU)
+	if symbols != nil {
+		Debugger.Symbols = symbols
 	}
-	if ((^op1&op2)|(^(op1^op2)&result))>>7 != 0 {
-		FlagsRegister |= CarryFlagC
-	} else {
-		FlagsRegister &= (^CarryFlagC)
+	if symbolFile != "" {
+		symFile, err := os.Open(symbolFile)
+		if err != nil {
+			die(fmt.Sprintf("Problem reading symbol file: %v", err))
+		}
+		Debugger.Symbols, err = debugger.LoadSymbols(symFile)
+		symFile.Close()
+		if err != nil {
+			die(fmt.Sprintf("Problem reading symbol file: %v", err))
+		}
 	}
-	// the the MSb of the operands is the same and the MSb of the result is different, we overflowed the sign bit
-	if AccumulatorRegister&0x80 == InternalRegister&0x80 && AccumulatorRegister&0x80 != DataBus&0x80 {
-		FlagsRegister |= OverflowFlagV
-	} else {
-		FlagsRegister &= (^OverflowFlagV)
+
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt)
+	go func() {
+		for range interrupts {
+			Debugger.Interrupt()
+		}
+	}()
+
+	fmt.Println("***** DJE-8 Debugger ***** (type help for commands)")
+	if err := Debugger.REPL(os.Stdin, os.Stdout); err != nil {
+		die(err.Error())
 	}
-	if result == 0 {
-		FlagsRegister |= ZeroFlagZ
-	} else {
-		FlagsRegister &= (^ZeroFlagZ)
+}
+
+// peekRange copies the inclusive range start..end off b without side effects
+func peekRange(b bus.Bus, start uint16, end uint16) []byte {
+	bytes := make([]byte, 0, int(end)-int(start)+1)
+	for i := int(start); i <= int(end); i++ {
+		bytes = append(bytes, bus.Peek(b, uint16(i)))
 	}
-	if result&0x80 != 0 {
-		FlagsRegister |= NegativeFlagN
-	} else {
-		FlagsRegister &= (^NegativeFlagN)
+	return bytes
+}
+
+// runToLimit steps until the CPU halts or, if a cycle limit was given,
+// until that many microsteps have been executed.
+func runToLimit(CPU *cpu.CPU) {
+	for !CPU.Halted && (cycleLimit == 0 || CPU.Cycles < cycleLimit) {
+		CPU.Step()
 	}
+}
+
+// Report is the final state written by -j
+type Report struct {
+	cpu.Snapshot
+	Memory MemoryDump `json:"memory"`
+	Screen []string   `json:"screen,omitempty"` // the text on the video display, one string per row
+}
 
-	return uint8(result)
+// MemoryDump holds a contiguous, inclusive range of memory as a hex string
+type MemoryDump struct {
+	Start uint16 `json:"start"`
+	End   uint16 `json:"end"`
+	Bytes string `json:"bytes"`
+}
+
+// isFlagSet reports whether the flag name was given on the command line
+func isFlagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+func die(message string) {
+	if restoreTerminal != nil {
+		restoreTerminal()
+	}
+	fmt.Fprintln(os.Stderr, message)
+	os.Exit(1)
 }
 
 func PrintEmulationHeaderPadding() {
@@ -352,19 +426,43 @@ func PrintEmulationHeaderPadding() {
 	}
 }
 
-func PrintSnapshot() {
+func PrintRegisters(c *cpu.CPU) {
+	fmt.Printf("PC: 0x%04x  MAR: 0x%04x  SP: 0x%04x  IR: 0x%02x (%s)\n", c.ProgramCounter, c.MemoryAddressRegister, c.StackPointer, c.InstructionRegister, OpCode(c.InstructionRegister))
+	fmt.Printf("A:  0x%02x (%3d)  B: 0x%02x (%3d)  F: %s (0x%02x)  Cycles: %d\n", c.AccumulatorRegister, c.AccumulatorRegister, c.InternalRegister, c.InternalRegister, formatFlagByte(c.FlagsRegister), uint8(c.FlagsRegister), c.Cycles)
+	if c.DMACycles != 0 {
+		fmt.Printf("DMA Cycles: %d\n", c.DMACycles)
+	}
+}
+
+// PrintMemory prints the inclusive range start..end in a format similar to hexdump
+func PrintMemory(c *cpu.CPU, start uint16, end uint16) {
+	for i := int(start); i <= int(end); i++ {
+		if (i-int(start))%16 == 0 {
+			fmt.Printf("%04x: ", i)
+		}
+		fmt.Printf(" %02x", bus.Peek(c.Bus, uint16(i)))
+		if (i-int(start)+1)%8 == 0 {
+			fmt.Print(" ")
+		}
+		if (i-int(start)+1)%16 == 0 || i == int(end) {
+			fmt.Println()
+		}
+	}
+}
+
+func PrintSnapshot(c *cpu.CPU) {
 	fmt.Printf("\033[%dA", EmulationHeaderPaddingSize)
-	fmt.Printf("    PC:  0x%04x             A: 0x%02x (%3d)\n", ProgramCounter, AccumulatorRegister, AccumulatorRegister)
-	fmt.Printf("    MAR: 0x%04x             B: 0x%02x (%3d)\n", MemoryAddressRegister, InternalRegister, InternalRegister)
-	fmt.Printf("    IR:  0x%02x (%4s)  Step: 0x%x   F: %s (0x%02x)\n", InstructionRegister, OpCode(InstructionRegister), ClockPulse, formatFlagByte(FlagsRegister), uint8(FlagsRegister))
-	fmt.Printf("    ROM Lookup: 0b%016b (0x%04x)\n", ROMAddress, ROMAddress)
-	fmt.Printf("    Control Wd: %s\n", formatControlWord(ControlWord))
+	fmt.Printf("    PC:  0x%04x             A: 0x%02x (%3d)\n", c.ProgramCounter, c.AccumulatorRegister, c.AccumulatorRegister)
+	fmt.Printf("    MAR: 0x%04x             B: 0x%02x (%3d)\n", c.MemoryAddressRegister, c.InternalRegister, c.InternalRegister)
+	fmt.Printf("    IR:  0x%02x (%4s)  Step: 0x%x   F: %s (0x%02x)\n", c.InstructionRegister, OpCode(c.InstructionRegister), c.ClockPulse, formatFlagByte(c.FlagsRegister), uint8(c.FlagsRegister))
+	fmt.Printf("    ROM Lookup: 0b%016b (0x%04x)\n", c.ROMAddress, c.ROMAddress)
+	fmt.Printf("    Control Wd: %s\n", formatControlWord(c.ControlWord))
 	fmt.Print(formatControlWordLabels("                "))
 	fmt.Println()
-	fmt.Printf("    AddrBus: 0b%016b (0x%04x)  DataBus: 0b%08b\n", AddressBus, AddressBus, DataBus)
+	fmt.Printf("    AddrBus: 0b%016b (0x%04x)  DataBus: 0b%08b\n", c.AddressBus, c.AddressBus, c.DataBus)
 	fmt.Printf("    RAM:")
 	for i := range 64 {
-		fmt.Printf(" %02x", MemorySpace[i])
+		fmt.Printf(" %02x", bus.Peek(c.Bus, uint16(i)))
 		if (i+1)%8 == 0 {
 			fmt.Print(" ")
 		}
@@ -373,6 +471,24 @@ func PrintSnapshot() {
 		}
 	}
 	fmt.Println()
+	if Video != nil {
+		fmt.Printf("    +%s+\n", strings.Repeat("-", devices.VideoColumns))
+		var pane strings.Builder
+		Video.Render(&pane)
+		for line := range strings.Lines(pane.String()) {
+			fmt.Printf("    |%s|\n", strings.TrimSuffix(line, "\n"))
+		}
+		fmt.Printf("    +%s+\n", strings.Repeat("-", devices.VideoColumns))
+	}
+}
+
+// PrintScreen prints the text on the video display in a frame
+func PrintScreen() {
+	fmt.Printf("+%s+\n", strings.Repeat("-", devices.VideoColumns))
+	for _, line := range Video.Text() {
+		fmt.Printf("|%s|\n", line)
+	}
+	fmt.Printf("+%s+\n", strings.Repeat("-", devices.VideoColumns))
 }
 
 func formatFlagByte(Flags Flag) string {
@@ -423,3 +539,180 @@ func formatControlWordLabels(Prefix string) string {
 	}
 	return retval.String()
 }
+
+// *** CLI FLag Stuff ***
+type AddressValue uint16
+type ROMValue []ROMImage
+type ROMImage struct {
+	Filename string
+	Address  uint16
+}
+type RangeValue struct {
+	Start uint16
+	End   uint16
+}
+type BankValue []BankWindow
+type BankWindow struct {
+	RangeValue
+	Count int
+}
+type BankImageValue []BankImage
+type BankImage struct {
+	ROMImage
+	Bank int
+}
+
+func init() {
+	const (
+		filenameUsage = "binary file to load (e.g. the output of asm -m b), or a .asm file to assemble and load\n" +
+			"if omitted the built-in test program is run"
+		originUsage     = "address at which the binary file is loaded (default: the .asm file's starting #org)"
+		entryUsage      = "address at which execution starts (default: the origin)"
+		headlessUsage   = "run with no display and no delay, then report the final state"
+		cycleLimitUsage = "stop after this many microsteps (0 = run until HALT)"
+		jsonOutUsage    = "in headless mode, write the final state as JSON to this file (- for stdout)"
+		dumpRangeUsage  = "inclusive memory range reported in headless mode, e.g. 0x8000-0x80ff"
+		debugUsage      = "start the interactive step debugger"
+		symbolFileUsage = "symbol file (from asm -l) giving the debugger label names"
+		uartUsage       = "backend for serial port %d at 0x%04x: stdio, pty or tcp:ADDRESS"
+		timerUsage      = "map the interval timer at 0x%04x"
+		keyboardUsage   = "feed the keyboard interface at 0x%04x from stdio (the terminal, in raw mode) or a keystroke file"
+		videoUsage      = "attach the 80x25 text mode video controller (buffer at 0x%04x, registers at 0x%04x)"
+		dmaUsage        = "plug a DMA controller into expansion slot 1 at 0x%04x"
+		bankUsage       = "bank switch a window of memory as START-END:BANKS, e.g. 0x4000-0x7fff:16\n" +
+			"(repeatable, window n is selected by the register at 0x%04x+n)"
+		bankImageUsage = "load an image into a bank as FILE@ADDRESS:BANK, e.g. prog.asm.bank3.bin@0x4000:3 (repeatable)"
+		romUsage       = "map a write-protected ROM image as FILE@ADDRESS, e.g. boot.bin@0xE000\n" +
+			"may be repeated; the -f program may still be loaded into a ROM"
+	)
+	flag.StringVar(&filename, "f", "", filenameUsage)
+	flag.Var(&origin, "o", originUsage)
+	flag.Var(&entry, "e", entryUsage)
+	flag.BoolVar(&headless, "headless", false, headlessUsage)
+	flag.Uint64Var(&cycleLimit, "c", 0, cycleLimitUsage)
+	flag.StringVar(&jsonOut, "j", "", jsonOutUsage)
+	flag.Var(&dumpRange, "d", dumpRangeUsage)
+	flag.BoolVar(&debug, "debug", false, debugUsage)
+	flag.StringVar(&symbolFile, "y", "", symbolFileUsage)
+	flag.StringVar(&uart1, "uart1", "", fmt.Sprintf(uartUsage, 1, Serial1Start))
+	flag.StringVar(&uart2, "uart2", "", fmt.Sprintf(uartUsage, 2, Serial2Start))
+	flag.BoolVar(&timer, "timer", false, fmt.Sprintf(timerUsage, TimerStart))
+	flag.StringVar(&keyboard, "keyboard", "", fmt.Sprintf(keyboardUsage, KeyboardStart))
+	flag.BoolVar(&video, "video", false, fmt.Sprintf(videoUsage, VideoBufferStart, VideoRegistersStart))
+	flag.BoolVar(&dma, "dma", false, fmt.Sprintf(dmaUsage, Expansion1Start))
+	flag.Var(&roms, "rom", romUsage)
+	flag.Var(&banks, "bank", fmt.Sprintf(bankUsage, BankSelectStart))
+	flag.Var(&bankImages, "bankfile", bankImageUsage)
+}
+
+func (v *AddressValue) String() string {
+	return fmt.Sprintf("0x%04x", uint16(*v))
+}
+
+func (v *AddressValue) Set(s string) error {
+	if temp, err := strconv.ParseUint(s, 0, 16); err != nil {
+		return err
+	} else {
+		*v = AddressValue(temp)
+	}
+	return nil
+}
+
+func (v *RangeValue) String() string {
+	return fmt.Sprintf("0x%04x-0x%04x", v.Start, v.End)
+}
+
+func (v *RangeValue) Set(s string) error {
+	startStr, endStr, found := strings.Cut(s, "-")
+	if !found {
+		return fmt.Errorf("cannot process %s into a range, expected start-end", s)
+	}
+	start, err := strconv.ParseUint(startStr, 0, 16)
+	if err != nil {
+		return err
+	}
+	end, err := strconv.ParseUint(endStr, 0, 16)
+	if err != nil {
+		return err
+	}
+	if end < start {
+		return fmt.Errorf("range %s ends before it starts", s)
+	}
+	v.Start, v.End = uint16(start), uint16(end)
+	return nil
+}
+
+func (v *ROMValue) String() string {
+	specs := []string{}
+	for _, rom := range *v {
+		specs = append(specs, fmt.Sprintf("%s@0x%04x", rom.Filename, rom.Address))
+	}
+	return strings.Join(specs, ",")
+}
+
+func (v *ROMValue) Set(s string) error {
+	filename, addressStr, found := strings.Cut(s, "@")
+	if !found {
+		return fmt.Errorf("cannot process %s into a ROM, expected FILE@ADDRESS", s)
+	}
+	address, err := strconv.ParseUint(addressStr, 0, 16)
+	if err != nil {
+		return err
+	}
+	*v = append(*v, ROMImage{filename, uint16(address)})
+	return nil
+}
+
+func (v *BankValue) String() string {
+	specs := []string{}
+	for _, window := range *v {
+		specs = append(specs, fmt.Sprintf("%s:%d", window.RangeValue.String(), window.Count))
+	}
+	return strings.Join(specs, ",")
+}
+
+func (v *BankValue) Set(s string) error {
+	rangeStr, countStr, found := strings.Cut(s, ":")
+	if !found {
+		return fmt.Errorf("cannot process %s into a banked window, expected START-END:BANKS", s)
+	}
+	var window BankWindow
+	if err := window.RangeValue.Set(rangeStr); err != nil {
+		return err
+	}
+	count, err := strconv.ParseUint(countStr, 0, 16)
+	if err != nil {
+		return err
+	}
+	if count < 1 || count > 256 {
+		return fmt.Errorf("a window has 1 to 256 banks, not %d", count)
+	}
+	window.Count = int(count)
+	*v = append(*v, window)
+	return nil
+}
+
+func (v *BankImageValue) String() string {
+	specs := []string{}
+	for _, image := range *v {
+		specs = append(specs, fmt.Sprintf("%s@0x%04x:%d", image.Filename, image.Address, image.Bank))
+	}
+	return strings.Join(specs, ",")
+}
+
+func (v *BankImageValue) Set(s string) error {
+	idx := strings.LastIndex(s, ":")
+	if idx < 0 {
+		return fmt.Errorf("cannot process %s into a bank image, expected FILE@ADDRESS:BANK", s)
+	}
+	var images ROMValue
+	if err := images.Set(s[:idx]); err != nil {
+		return err
+	}
+	bank, err := strconv.ParseUint(s[idx+1:], 0, 8)
+	if err != nil {
+		return err
+	}
+	*v = append(*v, BankImage{images[0], int(bank)})
+	return nil
+}
@@ -0,0 +1,590 @@
+// Package asm is the DJE-8 assembler (see cmd/asm/assembly_language_SPEC.md).
+// It assembles source held in memory and reports every problem it finds as
+// a Diagnostic rather than stopping at the first one.
+package asm
+
+import (
+	"bufio"
+	"cmp"
+	"fmt"
+	"io"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"damien.live/dje8/pkg/common"
+)
+
+// Options control an assembly
+type Options struct {
+	Filename string // name of the source, used in diagnostics and to find the files it includes
+	// ReadFile, when set, reads the files named by #include and #incbin in
+	// place of os.ReadFile
+	ReadFile func(name string) ([]byte, error)
+}
+
+// Diagnostic is a problem found in the source, located by file, line and
+// column (both counted from 1)
+type Diagnostic struct {
+	File    string
+	Line    int
+	Column  int
+	Message string
+}
+
+func (d Diagnostic) Error() string {
+	return fmt.Sprintf("%s:%d:%d: %s", d.File, d.Line, d.Column, d.Message)
+}
+
+// Program is the result of an assembly
+type Program struct {
+	Origin  uint16            // address of Bytes[0]
+	Bytes   []byte            // main memory image, #org gaps filled with 0x00
+	Sources []Source          // where each of Bytes came from
+	Banks   []Bank            // code placed in banks with #bank, in source order
+	Symbols map[string]uint16 // label addresses
+	Listing *Listing
+}
+
+// Bank is the code placed in one bank of a banked window by #bank
+type Bank struct {
+	Bank    int
+	Origin  uint16 // address of Bytes[0] within the window
+	Bytes   []byte
+	Sources []Source
+}
+
+// Segment is a run of bytes with no #org padding in it
+type Segment struct {
+	Address uint16
+	Bytes   []byte
+}
+
+// Segments returns the bytes of p as the runs between #org gaps
+func (p *Program) Segments() []Segment {
+	return segments(p.Origin, p.Bytes, p.Sources)
+}
+
+// Segments returns the bytes of b as the runs between #org gaps
+func (b *Bank) Segments() []Segment {
+	return segments(b.Origin, b.Bytes, b.Sources)
+}
+
+func segments(origin uint16, bytes []byte, sources []Source) []Segment {
+	var segments []Segment
+	start := -1
+	for i := 0; i <= len(bytes); i++ {
+		isPadding := i == len(bytes) || sources[i] == Source{}
+		if isPadding && start >= 0 {
+			segments = append(segments, Segment{origin + uint16(start), bytes[start:i]})
+			start = -1
+		} else if !isPadding && start < 0 {
+			start = i
+		}
+	}
+	return segments
+}
+
+// Source is the line a byte was assembled from.  It is the zero Source for
+// #org padding, and the line in the macro for a byte from a macro.
+type Source struct {
+	File string
+	Line int
+}
+
+// position locates a field in the source
+type position struct {
+	file      string
+	line      int
+	column    int
+	expansion *expansion // the macro use that produced the field, if any
+	statement int        // line the field belongs to; each line of each macro expansion is a new one
+}
+
+type field struct {
+	content string
+	pos     position
+}
+
+// token is one byte of output, or one byte of a reference to be filled in
+// by the second pass
+type token struct {
+	value byte
+	ref   *reference
+	index int // byte of the reference's value, 0 for the LSB
+	pos   position
+}
+
+// reference is an expression whose value is only known once every label
+// has been placed, so it is evaluated by the second pass
+type reference struct {
+	source   string
+	pos      position
+	ctx      context
+	selector byte                  // '<' or '>' if the expression selects one byte
+	size     int                   // number of bytes the value is placed in
+	mode     common.AddressingMode // the range a whole value is checked against; Implied for data
+	value    int64
+	done     bool
+}
+
+// instruction is the instruction whose operand is expected next, on the same
+// line
+type instruction struct {
+	opcode common.OpCode
+	mode   common.AddressingMode
+	pos    position
+	ctx    context // at the opcode, so $ in the operand is its address
+	done   bool    // the operand has been seen
+}
+
+// bankSegment is the code placed in a bank by #bank.  It runs from
+// tokens[first] up to the start of the next segment.
+type bankSegment struct {
+	bank   int
+	first  int
+	origin uint16
+}
+
+type assembler struct {
+	opts           Options
+	fields         []field
+	tokens         []token
+	currentAddress uint16
+	origin         uint16
+	originSet      bool
+	labels         map[string]uint16
+	labelPos       map[string]position
+	scope          string   // the last global label, which .local labels belong to
+	backwardLabels []uint16 // addresses of the - anonymous labels
+	forwardLabels  []uint16 // addresses of the + anonymous labels
+	macros         map[string]*macro
+	expansions     int
+	lines          int
+	constants      map[string]*constant
+	constantOrder  []*constant
+	bankSegments   []bankSegment
+	pending        *instruction
+	diagnostics    []Diagnostic
+	listing        []*ListingLine         // the lines of the source, in order
+	listed         map[int]*ListingLine   // the line of each statement
+	expanded       map[int][]*ListingLine // the lines of the macro used by a statement
+	uses           map[string][]position  // where each symbol is used
+}
+
+// Assemble assembles src.  The Program is returned even when there are
+// diagnostics, as far as it could be assembled; it should only be used if
+// there are none.
+func Assemble(src io.Reader, opts Options) (*Program, []Diagnostic) {
+	a := &assembler{opts: opts, labels: make(map[string]uint16), labelPos: make(map[string]position), macros: make(map[string]*macro), constants: make(map[string]*constant),
+		listed: make(map[int]*ListingLine), expanded: make(map[int][]*ListingLine), uses: make(map[string][]position)}
+	if err := a.split(src, opts.Filename, nil); err != nil {
+		a.errorf(position{}, "%v", err)
+		return &Program{Symbols: a.labels, Listing: &Listing{}}, a.diagnostics
+	}
+	a.fields = a.expand(a.fields, 0)
+	a.firstPass()
+	a.secondPass()
+	slices.SortStableFunc(a.diagnostics, func(x, y Diagnostic) int {
+		return cmp.Or(cmp.Compare(x.File, y.File), cmp.Compare(x.Line, y.Line), cmp.Compare(x.Column, y.Column))
+	})
+	return a.program(), a.diagnostics
+}
+
+func (a *assembler) errorf(pos position, format string, args ...any) {
+	message := fmt.Sprintf(format, args...)
+	for e := pos.expansion; e != nil; e = e.pos.expansion {
+		message += fmt.Sprintf(" (in macro %s used at %s:%d:%d)", e.macro, e.pos.file, e.pos.line, e.pos.column)
+	}
+	a.diagnostics = append(a.diagnostics, Diagnostic{a.fileName(pos), pos.line, pos.column, message})
+}
+
+// fileName returns the name of the file pos is in
+func (a *assembler) fileName(pos position) string {
+	if pos.file == "" {
+		return a.opts.Filename
+	}
+	return pos.file
+}
+
+// split breaks the source into whitespace separated fields, dropping
+// comments (from ; to the end of the line) and treating commas as spaces.
+// Quoted string and character literals are kept whole.  Files named by
+// #include are split in its place; including lists the files being
+// included, to catch an #include cycle.
+func (a *assembler) split(src io.Reader, file string, including []string) error {
+	scanner := bufio.NewScanner(src)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := scanner.Text()
+		a.lines++
+		a.listed[a.lines] = &ListingLine{File: a.fileName(position{file: file}), Line: lineNo, Text: line, Bank: -1, statement: a.lines}
+		a.listing = append(a.listing, a.listed[a.lines])
+		lineStart := len(a.fields)
+		start, quoteStart := -1, -1
+		var quote byte
+		for i := 0; i <= len(line); i++ {
+			if quote != 0 && i < len(line) { // inside a literal
+				switch line[i] {
+				case '\\':
+					i++
+				case quote:
+					quote = 0
+				}
+				continue
+			}
+			end := i == len(line) || line[i] == ';'
+			separator := end || line[i] == ' ' || line[i] == '\t' || line[i] == ',' || line[i] == '\r'
+			if separator && start >= 0 {
+				a.fields = append(a.fields, field{line[start:i], position{file: file, line: lineNo, column: start + 1, statement: a.lines}})
+				start = -1
+			} else if !separator {
+				if start < 0 {
+					start = i
+				}
+				if line[i] == '"' || line[i] == '\'' {
+					quote, quoteStart = line[i], i
+				}
+			}
+			if end {
+				break
+			}
+		}
+		if quote != 0 { // the last field is the unterminated literal, which is dropped
+			a.errorf(position{file: file, line: lineNo, column: quoteStart + 1}, "unterminated %c literal", quote)
+			a.fields = a.fields[:len(a.fields)-1]
+		}
+		for i := lineStart; i < len(a.fields); i++ {
+			if a.fields[i].content == "#include" {
+				directive, args := a.fields[i], arguments(a.fields, i)
+				a.fields = a.fields[:i]
+				a.include(directive, args, append(slices.Clip(including), file))
+				break
+			}
+		}
+	}
+	return scanner.Err()
+}
+
+// arguments returns the fields after i on the same line, the argument list
+// of a directive or macro
+func arguments(fields []field, i int) []field {
+	j := i + 1
+	for j < len(fields) && fields[j].pos.statement == fields[i].pos.statement {
+		j++
+	}
+	return fields[i+1 : j]
+}
+
+// next returns the field after i for a directive's argument
+func (a *assembler) next(i int, directive field) (field, bool) {
+	if i+1 >= len(a.fields) {
+		a.errorf(directive.pos, "%s directive is missing its argument", directive.content)
+		return field{}, false
+	}
+	return a.fields[i+1], true
+}
+
+// firstPass processes each field except label pointers and maps label
+// addresses
+func (a *assembler) firstPass() {
+	for i := 0; i < len(a.fields); i++ {
+		currentField := a.fields[i]
+		if i > 0 && a.fields[i-1].pos.statement != currentField.pos.statement {
+			a.placed(a.fields[i-1].pos.statement)
+		}
+		if a.pending != nil && a.pending.pos.statement != currentField.pos.statement {
+			a.endInstruction()
+		}
+		if strings.HasPrefix(currentField.content, "#") { // DIRECTIVE
+			a.endInstruction()
+			switch currentField.content {
+			case "#org", "#bank":
+				// only #equ constants may come before the starting directive
+				isStartingDirective := i == 0 || len(a.tokens) == 0 && len(a.labels) == 0 && !a.originSet
+				argument, ok := a.next(i, currentField)
+				if !ok {
+					continue
+				}
+				i++
+				if currentField.content == "#org" {
+					a.org(isStartingDirective, argument)
+				} else {
+					a.bank(argument)
+				}
+			case "#byte", "#word", "#wordbe", "#string", "#pstring", "#fill", "#align":
+				args := arguments(a.fields, i)
+				i += len(args)
+				a.dataDirective(currentField, args)
+			case "#incbin":
+				args := arguments(a.fields, i)
+				i += len(args)
+				a.incbin(currentField, args)
+			case "#equ", "#define":
+				args := arguments(a.fields, i)
+				i += len(args)
+				a.equ(currentField, args)
+			default:
+				a.errorf(currentField.pos, "unknown assembler directive %s", currentField.content)
+			}
+		} else if strings.HasSuffix(currentField.content, ":") { // LABEL
+			a.label(currentField)
+		} else if opcode, found := common.OpCodeLookup[currentField.content]; found { // INSTRUCTION
+			a.endInstruction()
+			a.pending = &instruction{opcode: opcode, mode: common.AddressingModeLookup[opcode], pos: currentField.pos, ctx: a.context()}
+			a.emit(token{value: byte(opcode), pos: currentField.pos})
+			a.listed[currentField.pos.statement].OpCodes = append(a.listed[currentField.pos.statement].OpCodes, opcode)
+		} else if a.pending != nil { // OPERAND
+			a.operand(currentField)
+		} else if isData(currentField.content) {
+			a.data(currentField)
+		} else { // must be an expression, one byte if it selects one
+			ref := a.newReference(currentField, a.context(), 2, common.Implied)
+			if ref.selector != 0 {
+				ref.size = 1
+			}
+			a.emitReference(ref)
+		}
+	}
+	a.endInstruction()
+	if len(a.fields) > 0 {
+		a.placed(a.fields[len(a.fields)-1].pos.statement)
+	}
+}
+
+// endInstruction checks that the pending instruction was given its operand
+func (a *assembler) endInstruction() {
+	if a.pending != nil && !a.pending.done && a.pending.mode != common.Implied {
+		a.errorf(a.pending.pos, "%s is missing its operand (%s, %d byte(s))", a.pending.opcode, a.pending.mode, a.pending.mode.OperandBytes())
+	}
+	a.pending = nil
+}
+
+// operand emits the operand of the pending instruction, sized for its
+// addressing mode and range checked by the second pass
+func (a *assembler) operand(currentField field) {
+	in := a.pending
+	if in.done || in.mode == common.Implied {
+		if in.mode == common.Implied {
+			a.errorf(currentField.pos, "%s takes no operand", in.opcode)
+		} else {
+			a.errorf(currentField.pos, "too many operands for %s", in.opcode)
+		}
+		return
+	}
+	in.done = true
+	ref := a.newReference(currentField, in.ctx, in.mode.OperandBytes(), in.mode)
+	if ref.selector != 0 && (ref.size == 2 || in.mode == common.Relative) {
+		a.errorf(currentField.pos, "%s selects one byte but %s takes a 16-bit address", currentField.content, in.opcode)
+		ref.done = true
+	}
+	a.emitReference(ref)
+}
+
+// newReference returns a reference to the expression in f
+func (a *assembler) newReference(f field, ctx context, size int, mode common.AddressingMode) *reference {
+	selector, _ := splitSelector(f.content)
+	return &reference{source: f.content, pos: f.pos, ctx: ctx, selector: selector, size: size, mode: mode}
+}
+
+// emitReference emits the bytes of ref, LSB first
+func (a *assembler) emitReference(ref *reference) {
+	for i := range ref.size {
+		a.emit(token{ref: ref, index: i, pos: ref.pos})
+	}
+}
+
+func (a *assembler) emit(tokens ...token) {
+	a.tokens = append(a.tokens, tokens...)
+	a.currentAddress += uint16(len(tokens))
+}
+
+// org handles the #org directive.  The starting directive, and the first
+// one in a bank, set the address without creating padding.
+func (a *assembler) org(isStartingDirective bool, argument field) {
+	address, ok := a.constant(argument, 0, 0xffff)
+	if !ok {
+		return
+	}
+	if n := len(a.bankSegments); n > 0 && a.bankSegments[n-1].first == len(a.tokens) {
+		a.bankSegments[n-1].origin = uint16(address)
+		a.currentAddress = uint16(address)
+		return
+	}
+	if isStartingDirective {
+		a.origin, a.originSet = uint16(address), true
+		a.currentAddress = uint16(address)
+		return
+	}
+	if uint16(address) < a.currentAddress {
+		a.errorf(argument.pos, "invalid #org directive, 0x%04x is before the current address 0x%04x", address, a.currentAddress)
+		return
+	}
+	a.emit(make([]token, uint16(address)-a.currentAddress)...) // padding
+}
+
+// bank handles the #bank directive
+func (a *assembler) bank(argument field) {
+	bank, ok := a.constant(argument, 0, 0xff)
+	if !ok {
+		return
+	}
+	for _, segment := range a.bankSegments {
+		if segment.bank == int(bank) {
+			a.errorf(argument.pos, "invalid #bank directive, bank %d was already placed", bank)
+			return
+		}
+	}
+	a.bankSegments = append(a.bankSegments, bankSegment{int(bank), len(a.tokens), a.currentAddress})
+}
+
+// data handles a numeric or character literal.  Hexadecimal literals of
+// more than two digits and values over 255 take two bytes, LSB first.
+func (a *assembler) data(currentField field) {
+	if strings.HasPrefix(currentField.content, "'") {
+		value, _ := a.literal(currentField)
+		a.emit(token{value: byte(value), pos: currentField.pos})
+		return
+	}
+	isTwoBytes := (strings.HasPrefix(currentField.content, "0x") && len(currentField.content) > 4)
+	parsed, err := strconv.ParseUint(currentField.content, 0, 16)
+	if err != nil {
+		a.errorf(currentField.pos, "error parsing data %s: %v", currentField.content, numError(err))
+		a.emit(token{pos: currentField.pos})
+		return
+	}
+	isTwoBytes = isTwoBytes || parsed > 255
+	a.emit(token{value: byte(parsed), pos: currentField.pos}) // one byte or LSB
+	if isTwoBytes {
+		a.emit(token{value: byte(parsed >> 8), pos: currentField.pos}) // MSB
+	}
+}
+
+// secondPass fills in the references now that every label is known
+func (a *assembler) secondPass() {
+	a.resolveConstants()
+	for i := range a.tokens {
+		ref := a.tokens[i].ref
+		if ref == nil {
+			continue
+		}
+		if !ref.done {
+			a.resolve(ref)
+		}
+		a.tokens[i].value = byte(ref.value >> (8 * a.tokens[i].index))
+	}
+}
+
+// resolve evaluates ref and checks its value fits where it is placed
+func (a *assembler) resolve(ref *reference) {
+	ref.done = true
+	value, err := a.evaluate(ref.source, ref.pos, ref.ctx)
+	if err != nil {
+		if err != errReported {
+			a.errorf(ref.pos, "%v", err)
+		}
+		return
+	}
+	ref.value = value
+	if ref.selector != 0 {
+		return
+	}
+	switch {
+	case ref.mode == common.Relative:
+		a.branch(ref)
+	case ref.size == 2 && ref.mode == common.Absolute && (value < 0 || value > 0xffff):
+		a.errorf(ref.pos, "%s is %s, which is not a 16-bit address", ref.source, hex(value))
+	case ref.size == 2 && (value < -0x8000 || value > 0xffff):
+		a.errorf(ref.pos, "%s is %s, which does not fit in two bytes", ref.source, hex(value))
+	case ref.size == 1 && (ref.mode == common.ZeroPage || ref.mode == common.MemoryIndirect) && (value < 0 || value > 0xff):
+		a.errorf(ref.pos, "%s is %s, which is not on the ZeroPage", ref.source, hex(value))
+	case ref.size == 1 && (value < -0x80 || value > 0xff):
+		if isData(ref.source) {
+			a.errorf(ref.pos, "%s does not fit in one byte", ref.source)
+		} else {
+			a.errorf(ref.pos, "%s is %s, which does not fit in one byte; select a byte with <%s or >%s", ref.source, hex(value), ref.source, ref.source)
+		}
+	}
+}
+
+// branch replaces the target address of a branch with its offset from the
+// operand byte, which follows the opcode at $
+func (a *assembler) branch(ref *reference) {
+	if ref.value < 0 || ref.value > 0xffff {
+		a.errorf(ref.pos, "%s is %s, which is not a 16-bit address", ref.source, hex(ref.value))
+		return
+	}
+	offset := ref.value - (int64(ref.ctx.dollar) + 1)
+	if offset < -0x80 || offset > 0x7f {
+		a.errorf(ref.pos, "branch to %s is %d bytes away, out of range (-128 to 127)", ref.source, offset)
+		return
+	}
+	ref.value = offset
+}
+
+func (a *assembler) program() *Program {
+	mainTokens := a.tokens
+	if len(a.bankSegments) > 0 {
+		mainTokens = a.tokens[:a.bankSegments[0].first]
+	}
+	p := &Program{Origin: a.origin, Bytes: values(mainTokens), Sources: sources(mainTokens), Symbols: a.labels}
+	for i, segment := range a.bankSegments {
+		end := len(a.tokens)
+		if i+1 < len(a.bankSegments) {
+			end = a.bankSegments[i+1].first
+		}
+		tokens := a.tokens[segment.first:end]
+		p.Banks = append(p.Banks, Bank{segment.bank, segment.origin, values(tokens), sources(tokens)})
+	}
+	p.Listing = a.buildListing()
+	return p
+}
+
+func values(tokens []token) []byte {
+	bytes := make([]byte, len(tokens))
+	for i, token := range tokens {
+		bytes[i] = token.value
+	}
+	return bytes
+}
+
+func sources(tokens []token) []Source {
+	sources := make([]Source, len(tokens))
+	for i, token := range tokens {
+		sources[i] = Source{token.pos.file, token.pos.line}
+	}
+	return sources
+}
+
+var dataPattern = regexp.MustCompile(`^(('([^'\\]|\\.[^']*)')|([+-]?(0|[1-9][0-9]*))|(0[0-7]*)|(0x[0-9a-fA-F]*))$`)
+
+func isData(field string) bool {
+	return dataPattern.MatchString(field)
+}
+
+// literal returns the value of a numeric or character literal
+func (a *assembler) literal(f field) (int64, bool) {
+	if strings.HasPrefix(f.content, "'") {
+		text, err := strconv.Unquote(f.content)
+		if err != nil || len(text) != 1 {
+			a.errorf(f.pos, "invalid character literal %s", f.content)
+			return 0, false
+		}
+		return int64(text[0]), true
+	}
+	value, err := strconv.ParseInt(f.content, 0, 32)
+	if err != nil {
+		a.errorf(f.pos, "error parsing %s: %v", f.content, numError(err))
+		return 0, false
+	}
+	return value, true
+}
+
+// numError strips the strconv function name and input from err, which the
+// diagnostics already show
+func numError(err error) error {
+	if numErr, ok := err.(*strconv.NumError); ok {
+		return numErr.Err
+	}
+	return err
+}
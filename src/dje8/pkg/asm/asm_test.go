@@ -0,0 +1,349 @@
+package asm_test
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"maps"
+	"slices"
+	"strings"
+	"testing"
+
+	"damien.live/dje8/pkg/asm"
+	//lint:ignore ST1001 importing common shared across all dje8 cmds
+	. "damien.live/dje8/pkg/common"
+)
+
+// asmCase is a source and the bytes it assembles to, from its starting
+// #org, or every diagnostic it gives
+type asmCase struct {
+	Name   string
+	Source string
+	Want   []byte
+	Errors []string // "test.asm:line:column: message", in order
+}
+
+// files are what #include and #incbin read
+var files = map[string]string{
+	"defs.inc":  "#equ PORT 0xf000\n#macro out\nSTOA PORT\n#endm",
+	"lib/a.inc": "#include \"b.inc\"\nLODI 1",
+	"lib/b.inc": "LODI 2",
+	"font.bin":  "\x01\x02\x03\x04",
+	"loop.inc":  "#include \"loop.inc\"",
+	"bad.inc":   "; an error on line 2\nLODI 300",
+}
+
+func readFile(name string) ([]byte, error) {
+	if contents, found := files[name]; found {
+		return []byte(contents), nil
+	}
+	return nil, fs.ErrNotExist
+}
+
+func assemble(source string) (*asm.Program, []asm.Diagnostic) {
+	return asm.Assemble(strings.NewReader(source), asm.Options{Filename: "test.asm", ReadFile: readFile})
+}
+
+// run checks each case
+func run(t *testing.T, cases []asmCase) {
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			program, diagnostics := assemble(c.Source)
+			var errors []string
+			for _, diagnostic := range diagnostics {
+				errors = append(errors, diagnostic.Error())
+			}
+			if !slices.Equal(errors, c.Errors) {
+				t.Errorf("diagnostics:\n%s\nwant:\n%s", strings.Join(errors, "\n"), strings.Join(c.Errors, "\n"))
+			}
+			if c.Errors == nil && !bytes.Equal(program.Bytes, c.Want) {
+				t.Errorf("bytes % x, want % x", program.Bytes, c.Want)
+			}
+		})
+	}
+}
+
+func TestAssemble(t *testing.T) {
+	run(t, []asmCase{
+		{Name: "empty", Source: "", Want: []byte{}},
+		{Name: "instructions", Source: "#org 0x8000\nLODI 0x42\nSTOA 0x9000\nHALT",
+			Want: []byte{byte(LODI), 0x42, byte(STOA), 0x00, 0x90, byte(HALT)}},
+		{Name: "comments and commas", Source: "; a program\n#org 0x8000 ; start\nLODI 1 ; the operand, 1\n", Want: []byte{byte(LODI), 0x01}},
+		{Name: "data", Source: "#org 0x8000\n0x12 0x3456 'A' 042 300", Want: []byte{0x12, 0x56, 0x34, 'A', 042, 0x2c, 0x01}},
+		{Name: "org gap", Source: "#org 0x8000\n1\n#org 0x8004\n2", Want: []byte{0x01, 0x00, 0x00, 0x00, 0x02}},
+		{Name: "label reference", Source: "#org 0x8000\nstart: JMP start", Want: []byte{byte(JMP), 0x00, 0x80}},
+
+		{Name: "every error", Source: "#org 0x8000\n#foo\nLODI 1\nJMP nowhere\n#org 0x7000", Errors: []string{
+			"test.asm:2:1: unknown assembler directive #foo",
+			"test.asm:4:5: undefined symbol nowhere",
+			"test.asm:5:6: invalid #org directive, 0x7000 is before the current address 0x8005",
+		}},
+		{Name: "unterminated literal", Source: "#org 0x8000\nLODI 'A", Errors: []string{
+			"test.asm:2:1: LODI is missing its operand (Immediate, 1 byte(s))",
+			"test.asm:2:6: unterminated ' literal",
+		}},
+		{Name: "bad number", Source: "#org 0x8000\n0x1234567", Errors: []string{"test.asm:2:1: error parsing data 0x1234567: value out of range"}},
+		{Name: "org missing argument", Source: "#org", Errors: []string{"test.asm:1:1: #org directive is missing its argument"}},
+	})
+}
+
+func TestProgram(t *testing.T) {
+	program, diagnostics := assemble("#org 0x8000\nstart: LODI 1\nend: HALT")
+	if len(diagnostics) > 0 {
+		t.Fatal(diagnostics)
+	}
+	if program.Origin != 0x8000 {
+		t.Errorf("Origin = 0x%04x, want 0x8000", program.Origin)
+	}
+	if want := map[string]uint16{"start": 0x8000, "end": 0x8002}; !maps.Equal(program.Symbols, want) {
+		t.Errorf("Symbols = %v, want %v", program.Symbols, want)
+	}
+	if want := []asm.Source{{"test.asm", 2}, {"test.asm", 2}, {"test.asm", 3}}; !slices.Equal(program.Sources, want) {
+		t.Errorf("Sources = %v, want %v", program.Sources, want)
+	}
+}
+
+func TestOperands(t *testing.T) {
+	run(t, []asmCase{
+		{Name: "implied", Source: "#org 0x8000\nNOP\nHALT", Want: []byte{byte(NOP), byte(HALT)}},
+		{Name: "immediate", Source: "#org 0x8000\nLODI 0x42\nADDI 255\nSUBI -1", Want: []byte{byte(LODI), 0x42, byte(ADDI), 0xff, byte(SUBI), 0xff}},
+		{Name: "zero page", Source: "#org 0x8000\nLODZ 0x42\nSTOM 0xff", Want: []byte{byte(LODZ), 0x42, byte(STOM), 0xff}},
+		{Name: "absolute", Source: "#org 0x8000\nLODA 0x20\nSTOA 0x1234", Want: []byte{byte(LODA), 0x20, 0x00, byte(STOA), 0x34, 0x12}},
+		{Name: "byte selection", Source: "#org 0x8000\nhere: LODI <here\nLODI >here", Want: []byte{byte(LODI), 0x00, byte(LODI), 0x80}},
+		{Name: "zero page label", Source: "#org 0x0010\nzp: 0\nLODZ zp\nLODI zp", Want: []byte{0x00, byte(LODZ), 0x10, byte(LODI), 0x10}},
+		{Name: "label between", Source: "#org 0x8000\nJMP target: 0x8000", Want: []byte{byte(JMP), 0x00, 0x80}},
+
+		{Name: "operand to implied", Source: "#org 0x8000\nHALT 1", Errors: []string{"test.asm:2:6: HALT takes no operand"}},
+		{Name: "missing operand", Source: "#org 0x8000\nLODA\n0x1234", Errors: []string{"test.asm:2:1: LODA is missing its operand (Absolute, 2 byte(s))"}},
+		{Name: "too many operands", Source: "#org 0x8000\nLODI 1 2", Errors: []string{"test.asm:2:8: too many operands for LODI"}},
+		{Name: "immediate too big", Source: "#org 0x8000\nLODI 300\nLODI -129", Errors: []string{
+			"test.asm:2:6: 300 does not fit in one byte",
+			"test.asm:3:6: -129 does not fit in one byte",
+		}},
+		{Name: "immediate label", Source: "#org 0x8000\nhere: LODI here", Errors: []string{
+			"test.asm:2:12: here is 0x8000, which does not fit in one byte; select a byte with <here or >here",
+		}},
+		{Name: "off the zero page", Source: "#org 0x8000\nLODZ 0x100\nLODM -1", Errors: []string{
+			"test.asm:2:6: 0x100 is 0x0100, which is not on the ZeroPage",
+			"test.asm:3:6: -1 is -1, which is not on the ZeroPage",
+		}},
+		{Name: "absolute byte selection", Source: "#org 0x8000\nhere: LODA <here", Errors: []string{
+			"test.asm:2:12: <here selects one byte but LODA takes a 16-bit address",
+		}},
+		{Name: "absolute too big", Source: "#org 0x8000\nJMP 0x10000", Errors: []string{"test.asm:2:5: 0x10000 is 0x10000, which is not a 16-bit address"}},
+	})
+}
+
+func TestDataDirectives(t *testing.T) {
+	run(t, []asmCase{
+		{Name: "byte", Source: "#org 0x8000\n#byte 1, 2 'A' -1 \"Hi\"", Want: []byte{0x01, 0x02, 'A', 0xff, 'H', 'i'}},
+		{Name: "escapes", Source: `#org 0x8000` + "\n" + `#byte "a\n\x1b\"\\" '\''`, Want: []byte{'a', '\n', 0x1b, '"', '\\', '\''}},
+		{Name: "string", Source: "#org 0x8000\n#string \"Hi\"", Want: []byte{'H', 'i', 0x00}},
+		{Name: "pstring", Source: "#org 0x8000\n#pstring \"Hi\" '!'", Want: []byte{0x03, 'H', 'i', '!'}},
+		{Name: "word", Source: "#org 0x8000\n#word 0x1234, 1 -1", Want: []byte{0x34, 0x12, 0x01, 0x00, 0xff, 0xff}},
+		{Name: "wordbe", Source: "#org 0x8000\n#wordbe 0x1234", Want: []byte{0x12, 0x34}},
+		{Name: "word label", Source: "#org 0x8012\ntable: #word table >table", Want: []byte{0x12, 0x80, 0x80, 0x00}},
+		{Name: "fill", Source: "#org 0x8000\n#fill 3\n#fill 2, 0xff", Want: []byte{0x00, 0x00, 0x00, 0xff, 0xff}},
+		{Name: "align", Source: "#org 0x8000\n1\n#align 4\n2\n#align 2, 0xea\n#align 2", Want: []byte{0x01, 0x00, 0x00, 0x00, 0x02, 0xea}},
+
+		{Name: "missing argument", Source: "#org 0x8000\n#byte", Errors: []string{"test.asm:2:1: #byte directive is missing its argument"}},
+		{Name: "byte too big", Source: "#org 0x8000\ntable: #byte 256 table", Errors: []string{
+			"test.asm:2:14: 256 does not fit in one byte",
+			"test.asm:2:18: table is 0x8000, which does not fit in one byte; select a byte with <table or >table",
+		}},
+		{Name: "string in word", Source: "#org 0x8000\n#word \"Hi\"", Errors: []string{`test.asm:2:7: #word directive can't hold the string "Hi"`}},
+		{Name: "invalid string", Source: "#org 0x8000\n#byte \"\\q\"", Errors: []string{`test.asm:2:7: invalid string literal "\q"`}},
+		{Name: "pstring too long", Source: "#org 0x8000\n#pstring \"" + strings.Repeat("x", 256) + "\"", Errors: []string{
+			"test.asm:2:1: #pstring of 256 bytes is too long for its one byte length",
+		}},
+		{Name: "align not a power of two", Source: "#org 0x8000\n#align 3", Errors: []string{"test.asm:2:8: alignment 3 is not a power of two"}},
+		{Name: "fill too many arguments", Source: "#org 0x8000\n#fill 1 2 3", Errors: []string{"test.asm:2:11: too many arguments for #fill"}},
+		{Name: "fill value too big", Source: "#org 0x8000\n#fill 1 256", Errors: []string{"test.asm:2:9: 256 is 0x0100, which is out of range (-128 to 0x00ff)"}},
+	})
+}
+
+func TestExpressions(t *testing.T) {
+	run(t, []asmCase{
+		{Name: "precedence", Source: "#org 0x8000\n#byte 1+2*3 (1+2)*3 7/2 7%3 1<<4|1 0xf0>>4 0xff&0x0f^0x03 ~0&0xff -(-5) 'A'+1",
+			Want: []byte{7, 9, 3, 1, 0x11, 0x0f, 0x0c, 0xff, 5, 'B'}},
+		{Name: "byte selection", Source: "#org 0x80fe\nlabel: #byte <0x1234 >0x1234 <label+4 >label+4 <(label)+4",
+			Want: []byte{0x34, 0x12, 0x02, 0x81, 0x02}},
+		{Name: "dollar", Source: "#org 0x8000\nJMP $\n#org $+3\n2", Want: []byte{byte(JMP), 0x00, 0x80, 0x00, 0x00, 0x00, 0x02}},
+		{Name: "dollar in data", Source: "#org 0x8000\n#word $ $\n$ $", Want: []byte{0x00, 0x80, 0x00, 0x80, 0x04, 0x80, 0x06, 0x80}},
+		{Name: "equ", Source: "#equ SIZE 4\n#org 0x8000\n#fill SIZE\nLODI SIZE*2", Want: []byte{0x00, 0x00, 0x00, 0x00, byte(LODI), 0x08}},
+		{Name: "equ forward reference", Source: "#org 0x8000\nLODI COUNT\n#equ COUNT end-start\nstart: 1 2\nend:", Want: []byte{byte(LODI), 0x02, 0x01, 0x02}},
+		{Name: "define", Source: "#define PORT 0xf000\n#org 0x8000\nSTOA PORT+1", Want: []byte{byte(STOA), 0x01, 0xf0}},
+
+		{Name: "org needs earlier symbols", Source: "#org 0x8000\n#fill N\n#equ N 2", Errors: []string{
+			"test.asm:2:7: undefined symbol N (symbols used here must be defined before it)",
+		}},
+		{Name: "defined in terms of itself", Source: "#equ X Y\n#equ Y X+1\n#org 0x8000\nLODI X", Errors: []string{"test.asm:1:8: X is defined in terms of itself"}},
+		{Name: "division by zero", Source: "#org 0x8000\n#byte 1/0", Errors: []string{"test.asm:2:7: division by zero in 1/0"}},
+		{Name: "missing parenthesis", Source: "#org 0x8000\n#byte (1+2", Errors: []string{"test.asm:2:7: missing ) in (1+2"}},
+		{Name: "unexpected", Source: "#org 0x8000\n#byte 1)", Errors: []string{`test.asm:2:7: unexpected ")" in 1)`}},
+		{Name: "missing operand", Source: "#org 0x8000\n#byte 1+", Errors: []string{"test.asm:2:7: 1+ is missing an operand"}},
+		{Name: "already defined", Source: "#equ X 1\n#equ X 2\n#org 0x8000\nhere: 0\n#equ here 1", Errors: []string{
+			"test.asm:2:6: X is already defined",
+			"test.asm:5:6: here is already defined",
+		}},
+		{Name: "instruction as symbol", Source: "#equ LODI 1", Errors: []string{"test.asm:1:6: LODI is an instruction and can't be used as a symbol"}},
+		{Name: "equ without value", Source: "#equ X", Errors: []string{"test.asm:1:1: #equ directive needs a name and a value"}},
+	})
+}
+
+func TestMacros(t *testing.T) {
+	run(t, []asmCase{
+		{Name: "parameter", Source: "#macro add16 addr\nLODA addr\nADDI 1\nSTOA addr\n#endm\n#org 0x8000\nadd16 0x9000",
+			Want: []byte{byte(LODA), 0x00, 0x90, byte(ADDI), 0x01, byte(STOA), 0x00, 0x90}},
+		{Name: "expression argument", Source: "#macro load value\nLODI value*2\n#endm\n#org 0x8000\nload (1+2)", Want: []byte{byte(LODI), 0x06}},
+		{Name: "whole words outside literals", Source: "#macro s x\n#byte \"x\" 'x' x <xx\n#endm\n#org 0x8000\nxx: s 5",
+			Want: []byte{'x', 'x', 0x05, 0x00}},
+		{Name: "local labels", Source: "#macro wait\n@loop: BNE @loop\n#endm\n#org 0x8000\nwait\nwait", Want: []byte{byte(BNE), 0xff, byte(BNE), 0xff}},
+		{Name: "nested", Source: "#macro one\nLODI 1\n#endm\n#macro two\none\none\n#endm\n#org 0x8000\nstart: two", Want: []byte{byte(LODI), 0x01, byte(LODI), 0x01}},
+
+		{Name: "argument count", Source: "#macro m a b\n#endm\n#org 0x8000\nm 1", Errors: []string{"test.asm:4:1: macro m takes 2 argument(s), not 1"}},
+		{Name: "error in a macro", Source: "#macro bad\nLODI 300\n#endm\n#org 0x8000\nbad", Errors: []string{
+			"test.asm:2:6: 300 does not fit in one byte (in macro bad used at test.asm:5:1)",
+		}},
+		{Name: "uses itself", Source: "#macro r\nr\n#endm\n#org 0x8000\nr", Errors: []string{"test.asm:5:1: macros nested more than 16 deep, does r use itself?"}},
+		{Name: "no endm", Source: "#macro m\nLODI 1", Errors: []string{"test.asm:1:1: #macro has no #endm"}},
+		{Name: "endm without macro", Source: "#endm", Errors: []string{"test.asm:1:1: #endm without #macro"}},
+		{Name: "defined inside a macro", Source: "#macro m\n#macro n\n#endm", Errors: []string{"test.asm:2:1: #macro can't be defined inside a macro"}},
+		{Name: "instruction as macro", Source: "#macro HALT\n#endm", Errors: []string{"test.asm:1:8: HALT is an instruction and can't be used as a macro"}},
+		{Name: "defined twice", Source: "#macro m\n#endm\n#macro m\n#endm", Errors: []string{"test.asm:3:8: macro m is already defined"}},
+	})
+}
+
+func TestIncludes(t *testing.T) {
+	run(t, []asmCase{
+		{Name: "include", Source: "#include \"defs.inc\"\n#org 0x8000\nout", Want: []byte{byte(STOA), 0x00, 0xf0}},
+		{Name: "nested include", Source: "#org 0x8000\n#include \"lib/a.inc\"\nHALT", Want: []byte{byte(LODI), 0x02, byte(LODI), 0x01, byte(HALT)}},
+		{Name: "incbin", Source: "#org 0x8000\n#incbin \"font.bin\"\n#incbin \"font.bin\", 1\n#incbin \"font.bin\", 1, 2",
+			Want: []byte{0x01, 0x02, 0x03, 0x04, 0x02, 0x03, 0x04, 0x02, 0x03}},
+
+		{Name: "error in included file", Source: "#org 0x8000\n#include \"bad.inc\"", Errors: []string{"bad.inc:2:6: 300 does not fit in one byte"}},
+		{Name: "cycle", Source: "#include \"loop.inc\"", Errors: []string{"loop.inc:1:1: #include cycle, loop.inc includes itself"}},
+		{Name: "missing file", Source: "#include \"none.inc\"", Errors: []string{"test.asm:1:10: can't #include none.inc: file does not exist"}},
+		{Name: "unquoted", Source: "#incbin font.bin", Errors: []string{"test.asm:1:9: #incbin needs a file name in double quotes, not font.bin"}},
+		{Name: "incbin offset", Source: "#org 0x8000\n#incbin \"font.bin\", 5", Errors: []string{"test.asm:2:21: 5 is 0x0005, which is out of range (0x0000 to 0x0004)"}},
+		{Name: "incbin too many arguments", Source: "#org 0x8000\n#incbin \"font.bin\", 0, 1, 2", Errors: []string{"test.asm:2:27: too many arguments for #incbin"}},
+	})
+}
+
+func TestIncludeSources(t *testing.T) {
+	program, diagnostics := assemble("#org 0x8000\n#include \"lib/a.inc\"\n#incbin \"font.bin\", 0, 1")
+	if len(diagnostics) > 0 {
+		t.Fatal(diagnostics)
+	}
+	want := []asm.Source{{"lib/b.inc", 1}, {"lib/b.inc", 1}, {"lib/a.inc", 2}, {"lib/a.inc", 2}, {"test.asm", 3}}
+	if !slices.Equal(program.Sources, want) {
+		t.Errorf("Sources = %v, want %v", program.Sources, want)
+	}
+}
+
+func TestLabels(t *testing.T) {
+	run(t, []asmCase{
+		{Name: "local", Source: "#org 0x8000\nfirst: .loop: BNE .loop\nsecond: .loop: BNE .loop\nJMP first.loop",
+			Want: []byte{byte(BNE), 0xff, byte(BNE), 0xff, byte(JMP), 0x00, 0x80}},
+		{Name: "anonymous", Source: "#org 0x8000\n-: BNE -\nBEQ +\nNOP\n+: HALT", Want: []byte{byte(BNE), 0xff, byte(BEQ), 0x02, byte(NOP), byte(HALT)}},
+		{Name: "second nearest anonymous", Source: "#org 0x8000\n-: NOP\n-: BNE --\nBEQ ++\n+: NOP\n+: HALT",
+			Want: []byte{byte(NOP), byte(BNE), 0xfe, byte(BEQ), 0x02, byte(NOP), byte(HALT)}},
+
+		{Name: "defined twice", Source: "#org 0x8000\na: 0\na: 0", Errors: []string{"test.asm:3:1: label a is already defined at test.asm:2:1"}},
+		{Name: "instruction as label", Source: "#org 0x8000\nHALT: 0", Errors: []string{"test.asm:2:1: HALT is an instruction and can't be used as a label"}},
+		{Name: "invalid label", Source: "#org 0x8000\n1x: 0", Errors: []string{"test.asm:2:1: invalid label 1x"}},
+		{Name: "local before global", Source: "#org 0x8000\n.x: 0", Errors: []string{"test.asm:2:1: local label .x comes before any global label"}},
+		{Name: "macro label outside a macro", Source: "#org 0x8000\n@x: 0", Errors: []string{"test.asm:2:1: @x starts with @ but is not in a macro"}},
+		{Name: "undefined local", Source: "#org 0x8000\na: JMP .nope", Errors: []string{"test.asm:2:8: undefined symbol a.nope"}},
+		{Name: "no anonymous label", Source: "#org 0x8000\nBNE -\nBEQ ++\n+: HALT", Errors: []string{
+			"test.asm:2:5: - refers to the -: label 1 back, but there are only 0",
+			"test.asm:3:5: ++ refers to the +: label 2 ahead, but there are only 1",
+		}},
+	})
+}
+
+func TestBranches(t *testing.T) {
+	run(t, []asmCase{
+		{Name: "every condition", Source: "#org 0x8000\nBEQ $\nBNE $\nBCS $\nBCC $\nBMI $\nBPL $\nBVS $\nBVC $",
+			Want: []byte{byte(BEQ), 0xff, byte(BNE), 0xff, byte(BCS), 0xff, byte(BCC), 0xff, byte(BMI), 0xff, byte(BPL), 0xff, byte(BVS), 0xff, byte(BVC), 0xff}},
+		{Name: "furthest forward", Source: "#org 0x8000\nBNE target\n#fill 126\ntarget:", Want: append([]byte{byte(BNE), 0x7f}, make([]byte, 126)...)},
+		{Name: "furthest back", Source: "#org 0x8000\ntarget: #fill 127\nBNE target", Want: append(make([]byte, 127), byte(BNE), 0x80)},
+		{Name: "next instruction", Source: "#org 0x8000\nBEQ next\nnext: HALT", Want: []byte{byte(BEQ), 0x01, byte(HALT)}},
+
+		{Name: "too far forward", Source: "#org 0x8000\nBNE target\n#fill 127\ntarget:", Errors: []string{
+			"test.asm:2:5: branch to target is 128 bytes away, out of range (-128 to 127)",
+		}},
+		{Name: "too far back", Source: "#org 0x8000\ntarget: #fill 128\nBNE target", Errors: []string{
+			"test.asm:3:5: branch to target is -129 bytes away, out of range (-128 to 127)",
+		}},
+		{Name: "byte selection", Source: "#org 0x8000\nhere: BNE <here", Errors: []string{"test.asm:2:11: <here selects one byte but BNE takes a 16-bit address"}},
+		{Name: "not an address", Source: "#org 0x8000\nBNE 0x10000", Errors: []string{"test.asm:2:5: 0x10000 is 0x10000, which is not a 16-bit address"}},
+	})
+}
+
+// TestListing checks each line of the source and of a macro expansion is
+// listed with where it was placed and what it placed, and the symbols with
+// where they are used
+func TestListing(t *testing.T) {
+	program, diagnostics := assemble("#equ COUNT 2\n#macro twice\nLODI COUNT\nLODI COUNT\n#endm\n#org 0x8000\nstart: twice ; comment\nBNE start\n#byte 1 2 3 4 5\n#bank 1\n#org 0xc000\nJMP start")
+	if len(diagnostics) > 0 {
+		t.Fatal(diagnostics)
+	}
+	var lines []string
+	for _, line := range program.Listing.Lines {
+		lines = append(lines, fmt.Sprintf("%s:%d depth %d assembled %t %d:%04x [% x] %v %q", line.File, line.Line, line.Depth, line.Assembled, line.Bank, line.Address, line.Bytes, line.OpCodes, line.Text))
+	}
+	var symbols []string
+	for _, symbol := range program.Listing.Symbols {
+		symbols = append(symbols, fmt.Sprintf("%s %d %t %v %v", symbol.Name, symbol.Value, symbol.Constant, symbol.Defined, symbol.References))
+	}
+	wantLines := []string{
+		`test.asm:1 depth 0 assembled false -1:0000 [] [] "#equ COUNT 2"`,
+		`test.asm:2 depth 0 assembled false -1:0000 [] [] "#macro twice"`,
+		`test.asm:3 depth 0 assembled false -1:0000 [] [] "LODI COUNT"`,
+		`test.asm:4 depth 0 assembled false -1:0000 [] [] "LODI COUNT"`,
+		`test.asm:5 depth 0 assembled false -1:0000 [] [] "#endm"`,
+		`test.asm:6 depth 0 assembled true -1:8000 [] [] "#org 0x8000"`,
+		`test.asm:7 depth 0 assembled true -1:8000 [] [] "start: twice ; comment"`,
+		`test.asm:3 depth 1 assembled true -1:8000 [04 02] [LODI] "LODI COUNT"`,
+		`test.asm:4 depth 1 assembled true -1:8002 [04 02] [LODI] "LODI COUNT"`,
+		`test.asm:8 depth 0 assembled true -1:8004 [31 fb] [BNE] "BNE start"`,
+		`test.asm:9 depth 0 assembled true -1:8006 [01 02 03 04 05] [] "#byte 1 2 3 4 5"`,
+		`test.asm:10 depth 0 assembled true 1:800b [] [] "#bank 1"`,
+		`test.asm:11 depth 0 assembled true 1:c000 [] [] "#org 0xc000"`,
+		`test.asm:12 depth 0 assembled true 1:c000 [39 00 80] [JMP] "JMP start"`,
+	}
+	if !slices.Equal(lines, wantLines) {
+		t.Errorf("Lines:\n%s\nwant:\n%s", strings.Join(lines, "\n"), strings.Join(wantLines, "\n"))
+	}
+	wantSymbols := []string{
+		"COUNT 2 true {test.asm 1} [{test.asm 3} {test.asm 4}]",
+		"start 32768 false {test.asm 7} [{test.asm 8} {test.asm 12}]",
+	}
+	if !slices.Equal(symbols, wantSymbols) {
+		t.Errorf("Symbols:\n%s\nwant:\n%s", strings.Join(symbols, "\n"), strings.Join(wantSymbols, "\n"))
+	}
+}
+
+func TestSegments(t *testing.T) {
+	program, diagnostics := assemble("#org 0x8000\n1 0\n#org 0x8004\n3\n#bank 2\n#org 0xc000\n4")
+	if len(diagnostics) > 0 {
+		t.Fatal(diagnostics)
+	}
+	format := func(segments []asm.Segment) string {
+		var s []string
+		for _, segment := range segments {
+			s = append(s, fmt.Sprintf("%04x:[% x]", segment.Address, segment.Bytes))
+		}
+		return strings.Join(s, " ")
+	}
+	if got, want := format(program.Segments()), "8000:[01 00] 8004:[03]"; got != want {
+		t.Errorf("Segments() = %s, want %s", got, want)
+	}
+	if len(program.Banks) != 1 || program.Banks[0].Bank != 2 {
+		t.Fatalf("Banks = %v, want bank 2 only", program.Banks)
+	}
+	if got, want := format(program.Banks[0].Segments()), "c000:[04]"; got != want {
+		t.Errorf("bank 2 Segments() = %s, want %s", got, want)
+	}
+}
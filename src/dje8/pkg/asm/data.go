@@ -0,0 +1,120 @@
+package asm
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+
+	"damien.live/dje8/pkg/common"
+)
+
+// dataDirective handles the directives that place data:
+//
+//	#byte    values and strings, one byte each
+//	#word    16-bit values, LSB first like addresses
+//	#wordbe  16-bit values, MSB first
+//	#string  like #byte, followed by a 0 terminator
+//	#pstring like #byte, preceded by a one byte length
+//	#fill    count[, value] repeats value (default 0) count times
+//	#align   n[, value] pads with value (default 0) to a multiple of n
+func (a *assembler) dataDirective(directive field, args []field) {
+	if len(args) == 0 {
+		a.errorf(directive.pos, "%s directive is missing its argument", directive.content)
+		return
+	}
+	switch directive.content {
+	case "#byte":
+		a.emit(a.items(directive, args, 1, false)...)
+	case "#word":
+		a.emit(a.items(directive, args, 2, false)...)
+	case "#wordbe":
+		a.emit(a.items(directive, args, 2, true)...)
+	case "#string":
+		a.emit(a.items(directive, args, 1, false)...)
+		a.emit(token{pos: directive.pos})
+	case "#pstring":
+		tokens := a.items(directive, args, 1, false)
+		if len(tokens) > 0xff {
+			a.errorf(directive.pos, "#pstring of %d bytes is too long for its one byte length", len(tokens))
+		}
+		a.emit(token{value: byte(len(tokens)), pos: directive.pos})
+		a.emit(tokens...)
+	case "#fill":
+		count, ok := a.constant(args[0], 0, 0xffff)
+		value, ok2 := a.fillValue(directive, args)
+		if ok && ok2 {
+			a.repeat(int(count), value, directive.pos)
+		}
+	case "#align":
+		alignment, ok := a.constant(args[0], 1, 0x8000)
+		if ok && alignment&(alignment-1) != 0 {
+			a.errorf(args[0].pos, "alignment %d is not a power of two", alignment)
+			ok = false
+		}
+		value, ok2 := a.fillValue(directive, args)
+		if ok && ok2 {
+			a.repeat(int((alignment-int64(a.currentAddress)%alignment)%alignment), value, directive.pos)
+		}
+	}
+}
+
+// items converts the arguments of a data directive to values of size bytes
+// each, LSB first unless bigEndian.  Strings are only allowed when size is 1.
+func (a *assembler) items(directive field, args []field, size int, bigEndian bool) []token {
+	var tokens []token
+	for _, arg := range args {
+		content := arg.content
+		var item []token
+		switch {
+		case strings.HasPrefix(content, `"`):
+			text, err := strconv.Unquote(content)
+			if err != nil {
+				a.errorf(arg.pos, "invalid string literal %s", content)
+				continue
+			}
+			if size != 1 {
+				a.errorf(arg.pos, "%s directive can't hold the string %s", directive.content, content)
+				continue
+			}
+			for _, b := range []byte(text) {
+				item = append(item, token{value: b, pos: arg.pos})
+			}
+		default:
+			ref := a.newReference(arg, a.context(), size, common.Implied)
+			item = []token{{ref: ref, pos: arg.pos}}
+			switch {
+			case size == 2 && ref.selector != 0:
+				ref.size = 1
+				item = append(item, token{pos: arg.pos})
+			case size == 2:
+				item = append(item, token{ref: ref, index: 1, pos: arg.pos})
+			}
+		}
+		if bigEndian {
+			slices.Reverse(item)
+		}
+		tokens = append(tokens, item...)
+	}
+	return tokens
+}
+
+// fillValue returns the optional second argument of #fill and #align
+func (a *assembler) fillValue(directive field, args []field) (byte, bool) {
+	switch len(args) {
+	case 1:
+		return 0, true
+	case 2:
+		value, ok := a.constant(args[1], -0x80, 0xff)
+		return byte(value), ok
+	}
+	a.errorf(args[2].pos, "too many arguments for %s", directive.content)
+	return 0, false
+}
+
+func (a *assembler) repeat(count int, value byte, pos position) {
+	tokens := make([]token, count)
+	for i := range tokens {
+		tokens[i] = token{value: value, pos: pos}
+	}
+	a.emit(tokens...)
+}
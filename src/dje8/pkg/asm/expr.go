@@ -0,0 +1,320 @@
+package asm
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"damien.live/dje8/pkg/common"
+)
+
+// Expressions are written without spaces and evaluated with the usual
+// precedence, lowest first:
+//
+//	|   ^   &   << >>   + -   * / %   unary - + ~ < >
+//
+// Their operands are numbers, character literals, symbols (labels and
+// #equ constants), $ (the address of the instruction, directive or data
+// value the expression belongs to) and parenthesised expressions.  < and >
+// select the low and high byte of their operand; at the start of an
+// expression they apply to all of it, so <label+4 is the low byte of
+// label+4.  An expression that is only -s or +s refers to an anonymous
+// label (see label).
+
+// context is what an expression depends on besides the symbols: where it
+// is and which labels come before it
+type context struct {
+	dollar   uint16 // the value of $
+	scope    string // the global label .local labels belong to
+	backward int    // number of - anonymous labels before the expression
+	forward  int    // number of + anonymous labels before the expression
+}
+
+func (a *assembler) context() context {
+	return context{a.currentAddress, a.scope, len(a.backwardLabels), len(a.forwardLabels)}
+}
+
+// errReported is returned when evaluating a symbol whose own error has
+// already been reported where it was defined
+var errReported = errors.New("already reported")
+
+// constant is a symbol defined by #equ.  Its expression is evaluated when
+// first needed, so it may refer to labels defined after it.
+type constant struct {
+	name       string
+	source     string
+	pos        position
+	ctx        context
+	value      int64
+	resolved   bool
+	evaluating bool
+	err        error
+}
+
+var symbolPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// equ handles the #equ and #define directives: #equ NAME expression
+func (a *assembler) equ(directive field, args []field) {
+	if len(args) != 2 {
+		a.errorf(directive.pos, "%s directive needs a name and a value", directive.content)
+		return
+	}
+	name := args[0].content
+	_, isLabel := a.labels[name]
+	_, isInstruction := common.OpCodeLookup[name]
+	switch {
+	case !symbolPattern.MatchString(name):
+		a.errorf(args[0].pos, "invalid symbol name %s", name)
+	case isInstruction:
+		a.errorf(args[0].pos, "%s is an instruction and can't be used as a symbol", name)
+	case isLabel || a.constants[name] != nil:
+		a.errorf(args[0].pos, "%s is already defined", name)
+	default:
+		c := &constant{name: name, source: args[1].content, pos: args[1].pos, ctx: a.context()}
+		a.constants[name] = c
+		a.constantOrder = append(a.constantOrder, c)
+	}
+}
+
+// constant returns the value of an expression needed by the first pass,
+// which may only use symbols defined before it, checked to be between low
+// and high
+func (a *assembler) constant(f field, low, high int64) (int64, bool) {
+	value, err := a.evaluate(f.content, f.pos, a.context())
+	if err != nil {
+		if err != errReported {
+			a.errorf(f.pos, "%v (symbols used here must be defined before it)", err)
+		}
+		return 0, false
+	}
+	if value < low || value > high {
+		a.errorf(f.pos, "%s is %s, which is out of range (%s to %s)", f.content, hex(value), hex(low), hex(high))
+		return 0, false
+	}
+	return value, true
+}
+
+// resolveConstants evaluates every #equ constant once, so that an error in
+// one is reported where it is defined rather than wherever it is used
+func (a *assembler) resolveConstants() {
+	for _, c := range a.constantOrder {
+		if _, err := a.symbol(c.name); err != nil && err != errReported {
+			a.errorf(c.pos, "%v", err)
+			c.err = errReported
+		}
+	}
+}
+
+// symbol returns the value of a label or #equ constant
+func (a *assembler) symbol(name string) (int64, error) {
+	if address, found := a.labels[name]; found {
+		return int64(address), nil
+	}
+	c, found := a.constants[name]
+	switch {
+	case !found:
+		return 0, fmt.Errorf("undefined symbol %s", name)
+	case c.err != nil:
+		return 0, c.err
+	case c.resolved:
+		return c.value, nil
+	case c.evaluating:
+		return 0, fmt.Errorf("%s is defined in terms of itself", name)
+	}
+	c.evaluating = true
+	value, err := a.evaluate(c.source, c.pos, c.ctx)
+	c.evaluating = false
+	if err != nil {
+		return 0, err
+	}
+	c.value, c.resolved = value, true
+	return value, nil
+}
+
+// splitSelector separates a leading < or > byte selection from the rest of
+// an expression
+func splitSelector(source string) (byte, string) {
+	if len(source) > 1 && (source[0] == '<' || source[0] == '>') && source[1] != source[0] {
+		return source[0], source[1:]
+	}
+	return 0, source
+}
+
+// evaluate returns the value of the expression source found at pos and ctx
+func (a *assembler) evaluate(source string, pos position, ctx context) (int64, error) {
+	selector, rest := splitSelector(source)
+	var value int64
+	var err error
+	if rest != "" && (strings.Trim(rest, "-") == "" || strings.Trim(rest, "+") == "") {
+		value, err = a.anonymous(rest, ctx)
+	} else {
+		lookup := func(name string) (int64, error) {
+			a.uses[name] = append(a.uses[name], pos)
+			return a.symbol(name)
+		}
+		p := &parser{source: source, src: rest, ctx: ctx, lookup: lookup}
+		value, err = p.binary(1)
+		if err == nil && p.pos < len(p.src) {
+			err = fmt.Errorf("unexpected %q in %s", p.src[p.pos:], source)
+		}
+	}
+	switch selector {
+	case '<':
+		value &= 0xff
+	case '>':
+		value = value >> 8 & 0xff
+	}
+	return value, err
+}
+
+var binaryOperators = []string{"<<", ">>", "|", "^", "&", "+", "-", "*", "/", "%"}
+
+var binaryPrecedence = map[string]int{"|": 1, "^": 2, "&": 3, "<<": 4, ">>": 4, "+": 5, "-": 5, "*": 6, "/": 6, "%": 6}
+
+// parser is a precedence climbing parser that evaluates as it goes
+type parser struct {
+	source string // the whole expression, for errors
+	src    string
+	pos    int
+	ctx    context
+	lookup func(name string) (int64, error)
+}
+
+func (p *parser) binary(precedence int) (int64, error) {
+	left, err := p.unary()
+	if err != nil {
+		return 0, err
+	}
+	for {
+		op := p.operator()
+		opPrecedence, found := binaryPrecedence[op]
+		if !found || opPrecedence < precedence {
+			return left, nil
+		}
+		p.pos += len(op)
+		right, err := p.binary(opPrecedence + 1)
+		if err != nil {
+			return 0, err
+		}
+		switch op {
+		case "|":
+			left |= right
+		case "^":
+			left ^= right
+		case "&":
+			left &= right
+		case "<<", ">>":
+			if right < 0 || right > 63 {
+				return 0, fmt.Errorf("shift by %d in %s", right, p.source)
+			}
+			if op == "<<" {
+				left <<= right
+			} else {
+				left >>= right
+			}
+		case "+":
+			left += right
+		case "-":
+			left -= right
+		case "*":
+			left *= right
+		case "/", "%":
+			if right == 0 {
+				return 0, fmt.Errorf("division by zero in %s", p.source)
+			}
+			if op == "/" {
+				left /= right
+			} else {
+				left %= right
+			}
+		}
+	}
+}
+
+func (p *parser) operator() string {
+	for _, op := range binaryOperators {
+		if strings.HasPrefix(p.src[p.pos:], op) {
+			return op
+		}
+	}
+	return ""
+}
+
+func (p *parser) unary() (int64, error) {
+	if p.pos >= len(p.src) {
+		return 0, fmt.Errorf("%s is missing an operand", p.source)
+	}
+	c := p.src[p.pos]
+	switch {
+	case c == '-' || c == '+' || c == '~' || c == '<' || c == '>':
+		p.pos++
+		value, err := p.unary()
+		switch c {
+		case '-':
+			value = -value
+		case '~':
+			value = ^value
+		case '<':
+			value &= 0xff
+		case '>':
+			value = value >> 8 & 0xff
+		}
+		return value, err
+	case c == '(':
+		p.pos++
+		value, err := p.binary(1)
+		if err != nil {
+			return 0, err
+		}
+		if p.pos >= len(p.src) || p.src[p.pos] != ')' {
+			return 0, fmt.Errorf("missing ) in %s", p.source)
+		}
+		p.pos++
+		return value, nil
+	case c == '$':
+		p.pos++
+		return int64(p.ctx.dollar), nil
+	case c == '\'':
+		literal, err := strconv.QuotedPrefix(p.src[p.pos:])
+		if err == nil {
+			p.pos += len(literal)
+			if text, err := strconv.Unquote(literal); err == nil && len(text) == 1 {
+				return int64(text[0]), nil
+			}
+		}
+		return 0, fmt.Errorf("invalid character literal in %s", p.source)
+	case c >= '0' && c <= '9':
+		word := p.word()
+		value, err := strconv.ParseInt(word, 0, 64)
+		if err != nil {
+			return 0, fmt.Errorf("error parsing %s: %v", word, numError(err))
+		}
+		return value, nil
+	case c == '_' || c == '.' || c == '@' || c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z':
+		name := p.word()
+		if strings.HasPrefix(name, ".") {
+			name = p.ctx.scope + name
+		}
+		return p.lookup(name)
+	}
+	return 0, fmt.Errorf("unexpected %q in %s", p.src[p.pos:], p.source)
+}
+
+// word consumes a number or symbol: its first character and then a run of
+// letters, digits, underscores and dots
+func (p *parser) word() string {
+	start := p.pos
+	for p.pos++; p.pos < len(p.src) && (isWordByte(p.src[p.pos]) || p.src[p.pos] == '.'); p.pos++ {
+	}
+	return p.src[start:p.pos]
+}
+
+// hex formats a value for a diagnostic
+func hex(value int64) string {
+	if value < 0 {
+		return strconv.FormatInt(value, 10)
+	}
+	return fmt.Sprintf("0x%04x", value)
+}
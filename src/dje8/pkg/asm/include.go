@@ -0,0 +1,92 @@
+package asm
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// include handles #include "FILE", splitting FILE in place of the directive
+func (a *assembler) include(directive field, args []field, including []string) {
+	name, ok := a.fileArgument(directive, args, 1)
+	if !ok {
+		return
+	}
+	if slices.Contains(including, name) {
+		a.errorf(directive.pos, "#include cycle, %s includes itself", name)
+		return
+	}
+	data, err := a.readFile(name)
+	if err != nil {
+		a.errorf(args[0].pos, "can't #include %s: %v", name, err)
+		return
+	}
+	if err := a.split(bytes.NewReader(data), name, including); err != nil {
+		a.errorf(args[0].pos, "can't #include %s: %v", name, err)
+	}
+}
+
+// incbin handles #incbin "FILE"[, offset[, length]], placing the bytes of
+// FILE (from offset, and only length of them if given)
+func (a *assembler) incbin(directive field, args []field) {
+	name, ok := a.fileArgument(directive, args, 3)
+	if !ok {
+		return
+	}
+	data, err := a.readFile(name)
+	if err != nil {
+		a.errorf(args[0].pos, "can't #incbin %s: %v", name, err)
+		return
+	}
+	if len(args) > 1 {
+		offset, ok := a.constant(args[1], 0, int64(len(data)))
+		if !ok {
+			return
+		}
+		data = data[offset:]
+	}
+	if len(args) > 2 {
+		length, ok := a.constant(args[2], 0, int64(len(data)))
+		if !ok {
+			return
+		}
+		data = data[:length]
+	}
+	tokens := make([]token, len(data))
+	for i, value := range data {
+		tokens[i] = token{value: value, pos: directive.pos}
+	}
+	a.emit(tokens...)
+}
+
+// fileArgument returns the file named by the first of a directive's
+// arguments, found relative to the file containing the directive
+func (a *assembler) fileArgument(directive field, args []field, maxArgs int) (string, bool) {
+	if len(args) == 0 {
+		a.errorf(directive.pos, "%s directive is missing its file name", directive.content)
+		return "", false
+	}
+	if len(args) > maxArgs {
+		a.errorf(args[maxArgs].pos, "too many arguments for %s", directive.content)
+		return "", false
+	}
+	name, err := strconv.Unquote(args[0].content)
+	if err != nil || !strings.HasPrefix(args[0].content, `"`) {
+		a.errorf(args[0].pos, "%s needs a file name in double quotes, not %s", directive.content, args[0].content)
+		return "", false
+	}
+	if !filepath.IsAbs(name) {
+		name = filepath.Join(filepath.Dir(directive.pos.file), name)
+	}
+	return name, true
+}
+
+func (a *assembler) readFile(name string) ([]byte, error) {
+	if a.opts.ReadFile != nil {
+		return a.opts.ReadFile(name)
+	}
+	return os.ReadFile(name)
+}
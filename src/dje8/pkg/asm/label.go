@@ -0,0 +1,85 @@
+package asm
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"damien.live/dje8/pkg/common"
+)
+
+// Labels come in four kinds:
+//
+//	name:   a global label, which also starts a new scope for local labels
+//	.name:  a local label, really name.local after the last global label, so
+//	        .loop can be reused under every global label
+//	@name:  a macro local label, renamed for each use of the macro
+//	-: +:   anonymous labels; - and -- in an expression are the nearest and
+//	        second nearest -: before it, + and ++ the nearest and second
+//	        nearest +: after it
+
+var localLabelPattern = regexp.MustCompile(`^\.[A-Za-z0-9_]+$`)
+
+// label defines the label f
+func (a *assembler) label(f field) {
+	name := strings.TrimSuffix(f.content, ":")
+	switch {
+	case name == "-":
+		a.backwardLabels = append(a.backwardLabels, a.currentAddress)
+		return
+	case name == "+":
+		a.forwardLabels = append(a.forwardLabels, a.currentAddress)
+		return
+	case strings.HasPrefix(name, "."):
+		if !localLabelPattern.MatchString(name) {
+			a.errorf(f.pos, "invalid local label %s", name)
+			return
+		}
+		if a.scope == "" {
+			a.errorf(f.pos, "local label %s comes before any global label", name)
+			return
+		}
+		name = a.scope + name
+	case strings.HasPrefix(name, "@"):
+		if f.pos.expansion == nil {
+			a.errorf(f.pos, "%s starts with @ but is not in a macro", name)
+			return
+		}
+	default:
+		_, isInstruction := common.OpCodeLookup[name]
+		switch {
+		case !symbolPattern.MatchString(name):
+			a.errorf(f.pos, "invalid label %s", name)
+			return
+		case isInstruction:
+			a.errorf(f.pos, "%s is an instruction and can't be used as a label", name)
+			return
+		}
+		a.scope = name
+	}
+	if previous, found := a.labelPos[name]; found {
+		a.errorf(f.pos, "label %s is already defined at %s:%d:%d", name, a.fileName(previous), previous.line, previous.column)
+		return
+	}
+	if _, found := a.constants[name]; found {
+		a.errorf(f.pos, "%s is already defined by #equ", name)
+		return
+	}
+	a.labels[name] = a.currentAddress
+	a.labelPos[name] = f.pos
+}
+
+// anonymous returns the address of the anonymous label that run, a run of
+// -s or +s, refers to from ctx
+func (a *assembler) anonymous(run string, ctx context) (int64, error) {
+	if run[0] == '-' {
+		if i := ctx.backward - len(run); i >= 0 {
+			return int64(a.backwardLabels[i]), nil
+		}
+		return 0, fmt.Errorf("%s refers to the -: label %d back, but there are only %d", run, len(run), ctx.backward)
+	}
+	if i := ctx.forward + len(run) - 1; i < len(a.forwardLabels) {
+		return int64(a.forwardLabels[i]), nil
+	}
+	return 0, fmt.Errorf("%s refers to the +: label %d ahead, but there are only %d", run, len(run), len(a.forwardLabels)-ctx.forward)
+}
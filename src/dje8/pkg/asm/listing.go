@@ -0,0 +1,125 @@
+package asm
+
+import (
+	"slices"
+	"strings"
+
+	"damien.live/dje8/pkg/common"
+)
+
+// Listing pairs every line of the source, and every line of every macro
+// expansion, with the bytes it placed, and lists the symbols
+type Listing struct {
+	Lines   []ListingLine // in source order, each macro use followed by its expansion
+	Symbols []Symbol      // sorted by name
+}
+
+// ListingLine is a line of the source, or a line of a macro expansion with
+// its parameters replaced
+type ListingLine struct {
+	File      string
+	Line      int
+	Text      string
+	Depth     int    // macro nesting depth, 0 for a line of the source
+	Assembled bool   // the line had something to assemble, so Address and Bank are set
+	Address   uint16 // of the first byte, or where the line left off if it placed none
+	Bank      int    // bank the line was placed in, -1 for main memory
+	Bytes     []byte
+	OpCodes   []common.OpCode // instructions on the line, in order
+	statement int
+}
+
+// Symbol is a label or #equ constant with where it is defined and used
+type Symbol struct {
+	Name       string
+	Value      int64
+	Constant   bool
+	Defined    Source
+	References []Source // in source order, each line once
+}
+
+// listExpansion adds the lines of a macro expansion to the listing, after
+// the line using the macro
+func (a *assembler) listExpansion(name field, body []field, depth int) {
+	var lines []*ListingLine
+	for i, bodyField := range body {
+		if i > 0 && body[i-1].pos.statement == bodyField.pos.statement {
+			lines[len(lines)-1].Text += " " + bodyField.content
+			continue
+		}
+		line := &ListingLine{File: a.fileName(bodyField.pos), Line: bodyField.pos.line, Text: bodyField.content, Depth: depth, Bank: -1, statement: bodyField.pos.statement}
+		a.listed[bodyField.pos.statement] = line
+		lines = append(lines, line)
+	}
+	a.expanded[name.pos.statement] = append(a.expanded[name.pos.statement], lines...)
+}
+
+// placed records where the first pass left off after statement, once the
+// starting #org has given the addresses a meaning
+func (a *assembler) placed(statement int) {
+	if !a.originSet && len(a.tokens) == 0 {
+		return
+	}
+	line := a.listed[statement]
+	line.Assembled, line.Address, line.Bank = true, a.currentAddress, -1
+	if n := len(a.bankSegments); n > 0 {
+		line.Bank = a.bankSegments[n-1].bank
+	}
+}
+
+func (a *assembler) buildListing() *Listing {
+	segment := -1
+	address := a.origin
+	for i, token := range a.tokens {
+		if segment+1 < len(a.bankSegments) && a.bankSegments[segment+1].first == i {
+			segment++
+			address = a.bankSegments[segment].origin
+		}
+		if line := a.listed[token.pos.statement]; line != nil && token.pos.statement != 0 {
+			if len(line.Bytes) == 0 {
+				line.Address, line.Bank = address, -1
+				if segment >= 0 {
+					line.Bank = a.bankSegments[segment].bank
+				}
+			}
+			line.Bytes = append(line.Bytes, token.value)
+		}
+		address++
+	}
+
+	l := &Listing{}
+	var add func(lines []*ListingLine)
+	add = func(lines []*ListingLine) {
+		for _, line := range lines {
+			l.Lines = append(l.Lines, *line)
+			add(a.expanded[line.statement])
+		}
+	}
+	add(a.listing)
+
+	for name, address := range a.labels {
+		l.Symbols = append(l.Symbols, Symbol{Name: name, Value: int64(address), Defined: a.source(a.labelPos[name]), References: a.references(name)})
+	}
+	for _, c := range a.constantOrder {
+		if value, err := a.symbol(c.name); err == nil {
+			l.Symbols = append(l.Symbols, Symbol{c.name, value, true, a.source(c.pos), a.references(c.name)})
+		}
+	}
+	slices.SortFunc(l.Symbols, func(x, y Symbol) int { return strings.Compare(x.Name, y.Name) })
+	return l
+}
+
+// references returns the lines name is used on
+func (a *assembler) references(name string) []Source {
+	var sources []Source
+	for _, pos := range a.uses[name] {
+		if source := a.source(pos); !slices.Contains(sources, source) {
+			sources = append(sources, source)
+		}
+	}
+	return sources
+}
+
+func (a *assembler) source(pos position) Source {
+	return Source{a.fileName(pos), pos.line}
+}
@@ -0,0 +1,173 @@
+package asm
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"damien.live/dje8/pkg/common"
+)
+
+// maxMacroDepth limits how deeply macros may use other macros, which
+// catches a macro that uses itself
+const maxMacroDepth = 16
+
+// macro is a macro defined with #macro NAME PARAM... and ended by #endm
+type macro struct {
+	name   string
+	params []string
+	body   []field
+}
+
+// expansion is a use of a macro, which its expanded fields point back to
+type expansion struct {
+	macro string
+	pos   position
+}
+
+// expand removes macro definitions from fields and replaces each use of a
+// macro, a macro name where an instruction could be, with its body
+func (a *assembler) expand(fields []field, depth int) []field {
+	var expanded []field
+	for i := 0; i < len(fields); i++ {
+		currentField := fields[i]
+		isStatement := i == 0 || fields[i-1].pos.statement != currentField.pos.statement || strings.HasSuffix(fields[i-1].content, ":")
+		switch m := a.macros[currentField.content]; {
+		case currentField.content == "#macro":
+			if depth > 0 {
+				a.errorf(currentField.pos, "#macro can't be defined inside a macro")
+			}
+			i = a.define(fields, i)
+		case currentField.content == "#endm":
+			a.errorf(currentField.pos, "#endm without #macro")
+		case m != nil && isStatement:
+			args := arguments(fields, i)
+			i += len(args)
+			expanded = append(expanded, a.use(m, currentField, args, depth)...)
+		default:
+			expanded = append(expanded, currentField)
+		}
+	}
+	return expanded
+}
+
+// define records the macro defined at fields[i] and returns the index of
+// its #endm
+func (a *assembler) define(fields []field, i int) int {
+	directive := fields[i]
+	header := arguments(fields, i)
+	end := i + 1 + len(header)
+	for end < len(fields) && fields[end].content != "#endm" {
+		if fields[end].content == "#macro" {
+			a.errorf(fields[end].pos, "#macro can't be defined inside a macro")
+		}
+		end++
+	}
+	if end == len(fields) {
+		a.errorf(directive.pos, "#macro has no #endm")
+	}
+	if len(header) == 0 {
+		a.errorf(directive.pos, "#macro directive is missing its name")
+		return end
+	}
+	m := &macro{name: header[0].content, body: fields[i+1+len(header) : end]}
+	_, isInstruction := common.OpCodeLookup[m.name]
+	switch {
+	case !symbolPattern.MatchString(m.name):
+		a.errorf(header[0].pos, "invalid macro name %s", m.name)
+		return end
+	case isInstruction:
+		a.errorf(header[0].pos, "%s is an instruction and can't be used as a macro", m.name)
+		return end
+	case a.macros[m.name] != nil:
+		a.errorf(header[0].pos, "macro %s is already defined", m.name)
+		return end
+	}
+	for _, param := range header[1:] {
+		if !symbolPattern.MatchString(param.content) {
+			a.errorf(param.pos, "invalid macro parameter %s", param.content)
+		}
+		m.params = append(m.params, param.content)
+	}
+	a.macros[m.name] = m
+	return end
+}
+
+// use expands a use of m with args.  Parameters are replaced wherever they
+// appear as a word outside string and character literals, and labels
+// starting with @ are renamed so that each expansion has its own.
+func (a *assembler) use(m *macro, name field, args []field, depth int) []field {
+	if depth >= maxMacroDepth {
+		pos := name.pos // reported where the outermost macro was used
+		for pos.expansion != nil {
+			pos = pos.expansion.pos
+		}
+		a.errorf(pos, "macros nested more than %d deep, does %s use itself?", maxMacroDepth, m.name)
+		return nil
+	}
+	if len(args) != len(m.params) {
+		a.errorf(name.pos, "macro %s takes %d argument(s), not %d", m.name, len(m.params), len(args))
+		return nil
+	}
+	a.expansions++
+	replacements := make(map[string]string)
+	for i, param := range m.params {
+		replacements[param] = args[i].content
+	}
+	site := &expansion{m.name, name.pos}
+	lines := make(map[int]int)
+	body := make([]field, len(m.body))
+	for i, bodyField := range m.body {
+		if _, found := lines[bodyField.pos.statement]; !found {
+			a.lines++
+			lines[bodyField.pos.statement] = a.lines
+		}
+		pos := bodyField.pos
+		pos.expansion, pos.statement = site, lines[bodyField.pos.statement]
+		body[i] = field{a.substitute(bodyField.content, replacements, m.name), pos}
+	}
+	a.listExpansion(name, body, depth+1)
+	return a.expand(body, depth+1)
+}
+
+// substitute replaces the parameters and renames the @ labels in content
+func (a *assembler) substitute(content string, replacements map[string]string, name string) string {
+	var b strings.Builder
+	for i := 0; i < len(content); {
+		c := content[i]
+		switch {
+		case c == '"' || c == '\'':
+			literal, err := strconv.QuotedPrefix(content[i:])
+			if err != nil {
+				literal = content[i:]
+			}
+			b.WriteString(literal)
+			i += len(literal)
+		case c == '@' || c == '_' || c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z':
+			start := i
+			for i++; i < len(content) && isWordByte(content[i]); i++ {
+			}
+			word := content[start:i]
+			if replacement, found := replacements[word]; found {
+				b.WriteString(replacement)
+			} else if c == '@' {
+				fmt.Fprintf(&b, "@%s_%d_%s", name, a.expansions, word[1:])
+			} else {
+				b.WriteString(word)
+			}
+		case c >= '0' && c <= '9': // numbers, which may contain letters
+			start := i
+			for i++; i < len(content) && isWordByte(content[i]); i++ {
+			}
+			b.WriteString(content[start:i])
+		default:
+			b.WriteByte(c)
+			i++
+		}
+	}
+	return b.String()
+}
+
+func isWordByte(c byte) bool {
+	return c == '_' || c >= '0' && c <= '9' || c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z'
+}
@@ -0,0 +1,63 @@
+package bus
+
+// Banked is a window of the address space showing one of several equally
+// sized physical banks of RAM at a time.  The bank is chosen through a
+// BankSelect register.
+type Banked struct {
+	Banks    []RAM
+	selected int
+}
+
+// NewBanked returns count banks of size bytes each with bank 0 selected
+func NewBanked(count int, size int) *Banked {
+	b := &Banked{Banks: make([]RAM, count)}
+	for i := range b.Banks {
+		b.Banks[i] = NewRAM(size)
+	}
+	return b
+}
+
+// Selected returns the bank currently shown in the window
+func (b *Banked) Selected() int {
+	return b.selected
+}
+
+// Select shows bank in the window.  A bank past the last one is not
+// populated: the window reads 0xff like the floating data bus and ignores
+// writes until a populated bank is selected.
+func (b *Banked) Select(bank int) {
+	b.selected = bank
+}
+
+func (b *Banked) Read8(address uint16) uint8 {
+	if b.selected >= len(b.Banks) {
+		return 0xff
+	}
+	return b.Banks[b.selected][address]
+}
+
+func (b *Banked) Write8(address uint16, value uint8) {
+	if b.selected < len(b.Banks) {
+		b.Banks[b.selected][address] = value
+	}
+}
+
+func (b *Banked) Peek8(address uint16) uint8        { return b.Read8(address) }
+func (b *Banked) Poke8(address uint16, value uint8) { b.Write8(address, value) }
+
+// BankSelect is the bank select register file: the register at offset n
+// holds the bank shown in window n.
+type BankSelect []*Banked
+
+func (s BankSelect) Read8(offset uint16) uint8 {
+	if int(offset) < len(s) {
+		return uint8(s[offset].Selected())
+	}
+	return 0xff // unused registers float high like the data bus
+}
+
+func (s BankSelect) Write8(offset uint16, value uint8) {
+	if int(offset) < len(s) {
+		s[offset].Select(int(value))
+	}
+}
@@ -0,0 +1,49 @@
+package bus_test
+
+import (
+	"testing"
+
+	"damien.live/dje8/pkg/bus"
+)
+
+func TestBankSelect(t *testing.T) {
+	window := bus.NewBanked(3, 0x100)
+	selects := bus.BankSelect{window}
+
+	// each bank keeps its own contents
+	for bank := 0; bank < 3; bank++ {
+		selects.Write8(0, uint8(bank))
+		window.Write8(0x10, uint8(0xa0+bank))
+	}
+	for _, c := range []struct {
+		bank uint8
+		want uint8
+	}{
+		{0, 0xa0},
+		{1, 0xa1},
+		{2, 0xa2},
+		{3, 0xff}, // past the last bank, not populated
+		{0xff, 0xff},
+	} {
+		selects.Write8(0, c.bank)
+		if got := selects.Read8(0); got != c.bank {
+			t.Errorf("bank select reads 0x%02x after writing 0x%02x", got, c.bank)
+		}
+		if got := window.Read8(0x10); got != c.want {
+			t.Errorf("bank 0x%02x reads 0x%02x, want 0x%02x", c.bank, got, c.want)
+		}
+	}
+
+	// writes to an unpopulated bank go nowhere
+	selects.Write8(0, 3)
+	window.Write8(0x10, 0x55)
+	for bank := 0; bank < 3; bank++ {
+		if got := window.Banks[bank][0x10]; got != uint8(0xa0+bank) {
+			t.Errorf("bank %d holds 0x%02x after a write to bank 3, want 0x%02x", bank, got, 0xa0+bank)
+		}
+	}
+
+	if got := selects.Read8(1); got != 0xff {
+		t.Errorf("unused bank select register reads 0x%02x, want 0xff", got)
+	}
+}
@@ -0,0 +1,84 @@
+package bus
+
+import (
+	"fmt"
+	"io"
+)
+
+// Bus is anything the CPU can read and write one byte at a time
+type Bus interface {
+	Read8(address uint16) uint8
+	Write8(address uint16, value uint8)
+}
+
+// Device is memory or a peripheral that can be mapped into a Decoder.  It
+// is addressed relative to the first address of the range it is mapped at.
+type Device = Bus
+
+// Peeker is implemented by devices that can be read without side effects
+// (e.g. without popping a receive buffer), for debuggers and dumps.
+type Peeker interface {
+	Peek8(address uint16) uint8
+}
+
+// Poker is implemented by devices whose contents can be loaded from
+// outside the machine, including write-protected ones, e.g. a ROM being
+// programmed.
+type Poker interface {
+	Poke8(address uint16, value uint8)
+}
+
+// Peek reads address from b without side effects if b supports it
+func Peek(b Bus, address uint16) uint8 {
+	if p, ok := b.(Peeker); ok {
+		return p.Peek8(address)
+	}
+	return b.Read8(address)
+}
+
+// Poke writes address in b bypassing write protection if b supports it
+func Poke(b Bus, address uint16, value uint8) {
+	if p, ok := b.(Poker); ok {
+		p.Poke8(address, value)
+		return
+	}
+	b.Write8(address, value)
+}
+
+// Load pokes data into b starting at address
+func Load(b Bus, address uint16, data []byte) error {
+	if int(address)+len(data) > 0x10000 {
+		return fmt.Errorf("%d bytes do not fit in memory at 0x%04x", len(data), address)
+	}
+	for i, value := range data {
+		Poke(b, address+uint16(i), value)
+	}
+	return nil
+}
+
+// RAM is plain read/write memory
+type RAM []byte
+
+func NewRAM(size int) RAM {
+	return make(RAM, size)
+}
+
+func (r RAM) Read8(address uint16) uint8         { return r[address] }
+func (r RAM) Write8(address uint16, value uint8) { r[address] = value }
+func (r RAM) Peek8(address uint16) uint8         { return r[address] }
+func (r RAM) Poke8(address uint16, value uint8)  { r[address] = value }
+
+// ROM is write-protected memory.  Writes from the CPU are ignored; its
+// contents can only be changed with Poke8.
+type ROM []byte
+
+// ReadROM returns a ROM holding the contents of r
+func ReadROM(r io.Reader) (ROM, error) {
+	image, err := io.ReadAll(r)
+	return ROM(image), err
+}
+
+func (r ROM) Read8(address uint16) uint8         { return r[address] }
+func (r ROM) Write8(address uint16, value uint8) {}
+func (r ROM) Peek8(address uint16) uint8         { return r[address] }
+func (r ROM) Poke8(address uint16, value uint8)  { r[address] = value }
@@ -0,0 +1,117 @@
+package bus
+
+import (
+	"fmt"
+
+	//lint:ignore ST1001 importing common shared across all dje8 cmds
+	. "damien.live/dje8/pkg/common"
+)
+
+// Region is one range of the address space routed to a device
+type Region struct {
+	Start  uint16
+	End    uint16 // inclusive
+	Name   string
+	Device Device
+}
+
+// Decoder is the address decoder: it routes every access to the device
+// mapped over that address.  Later mappings take priority over earlier
+// ones, so devices and ROMs can be laid over a RAM mapped across the
+// whole space.  Addresses with nothing mapped read as 0xff (the bus is
+// pulled high) and ignore writes.
+type Decoder struct {
+	regions []Region
+	lookup  [256][]int // candidate regions per 256 byte page, highest priority first
+}
+
+func NewDecoder() *Decoder {
+	return &Decoder{}
+}
+
+// NewStandard returns a decoder laid out per the SPEC.md memory map: 64K
+// of RAM with the I/O region 0xF000-0xFFFD left unmapped until devices are
+// attached with Map.  The interrupt vector at 0xFFFE-0xFFFF stays RAM
+// unless a ROM is mapped over it.
+func NewStandard() *Decoder {
+	d := NewDecoder()
+	ram := NewRAM(0x10000)
+	d.Map(0x0000, IOStart-1, "RAM", ram)
+	d.Map(InterruptVectorStart, InterruptVectorEnd, "Interrupt Vector", offsetDevice{ram, InterruptVectorStart})
+	return d
+}
+
+// Map routes start..end (inclusive) to device.  The device sees addresses
+// relative to start.
+func (d *Decoder) Map(start uint16, end uint16, name string, device Device) {
+	if end < start {
+		panic(fmt.Sprintf("bus: region %s ends (0x%04x) before it starts (0x%04x)", name, end, start))
+	}
+	d.regions = append(d.regions, Region{start, end, name, device})
+	idx := len(d.regions) - 1
+	for page := int(start >> 8); page <= int(end>>8); page++ {
+		d.lookup[page] = append([]int{idx}, d.lookup[page]...)
+	}
+}
+
+// MapROM lays rom over memory at start
+func (d *Decoder) MapROM(start uint16, rom ROM, name string) error {
+	if len(rom) == 0 || int(start)+len(rom) > 0x10000 {
+		return fmt.Errorf("ROM %s (%d bytes) does not fit at 0x%04x", name, len(rom), start)
+	}
+	d.Map(start, start+uint16(len(rom)-1), name, rom)
+	return nil
+}
+
+// Regions lists the mapped regions in the order they were mapped
+func (d *Decoder) Regions() []Region {
+	return d.regions
+}
+
+// RegionAt returns the region an access to address is routed to
+func (d *Decoder) RegionAt(address uint16) (Region, bool) {
+	for _, idx := range d.lookup[address>>8] {
+		if r := d.regions[idx]; address >= r.Start && address <= r.End {
+			return r, true
+		}
+	}
+	return Region{}, false
+}
+
+func (d *Decoder) Read8(address uint16) uint8 {
+	if r, found := d.RegionAt(address); found {
+		return r.Device.Read8(address - r.Start)
+	}
+	return 0xff
+}
+
+func (d *Decoder) Write8(address uint16, value uint8) {
+	if r, found := d.RegionAt(address); found {
+		r.Device.Write8(address-r.Start, value)
+	}
+}
+
+func (d *Decoder) Peek8(address uint16) uint8 {
+	if r, found := d.RegionAt(address); found {
+		return Peek(r.Device, address-r.Start)
+	}
+	return 0xff
+}
+
+func (d *Decoder) Poke8(address uint16, value uint8) {
+	if r, found := d.RegionAt(address); found {
+		Poke(r.Device, address-r.Start, value)
+	}
+}
+
+// offsetDevice exposes part of a larger device, e.g. the top of a RAM
+// that is otherwise hidden behind the I/O region
+type offsetDevice struct {
+	device Device
+	offset uint16
+}
+
+func (o offsetDevice) Read8(address uint16) uint8         { return o.device.Read8(address + o.offset) }
+func (o offsetDevice) Write8(address uint16, value uint8) { o.device.Write8(address+o.offset, value) }
+func (o offsetDevice) Peek8(address uint16) uint8         { return Peek(o.device, address+o.offset) }
+func (o offsetDevice) Poke8(address uint16, value uint8)  { Poke(o.device, address+o.offset, value) }
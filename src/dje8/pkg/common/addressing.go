@@ -0,0 +1,62 @@
+package common
+
+// The addressing modes an instruction can use to find its operand (see
+// SPEC.md)
+//
+//go:generate stringer -type=AddressingMode
+type AddressingMode uint8
+
+const (
+	Implied        AddressingMode = iota // no operand
+	Immediate                            // I: the operand byte is the value
+	Absolute                             // A: a 16-bit address
+	ZeroPage                             // Z: an 8-bit address on the ZeroPage
+	MemoryIndirect                       // M: an 8-bit ZeroPage address holding a 16-bit address
+	Relative                             // branch target, a signed 8-bit offset from the operand byte
+)
+
+// OperandBytes returns the number of bytes following the opcode of an
+// instruction using mode
+func (mode AddressingMode) OperandBytes() int {
+	switch mode {
+	case Immediate, ZeroPage, MemoryIndirect, Relative:
+		return 1
+	case Absolute:
+		return 2
+	}
+	return 0
+}
+
+// AddressingModeLookup gives the addressing mode of every instruction.  The
+// reserved opcodes are Implied.
+var AddressingModeLookup = map[OpCode]AddressingMode{
+	NOP: Implied, STOA: Absolute, STOZ: ZeroPage, STOM: MemoryIndirect,
+	LODI: Immediate, LODA: Absolute, LODZ: ZeroPage, LODM: MemoryIndirect,
+
+	NEG: Implied, ASL: Implied, ASR: Implied, NOT: Implied,
+	LSL: Implied, LSR: Implied, ROL: Implied, ROR: Implied,
+
+	ADDI: Immediate, ADDA: Absolute, ADDZ: ZeroPage, ADDM: MemoryIndirect,
+	SUBI: Immediate, SUBA: Absolute, SUBZ: ZeroPage, SUBM: MemoryIndirect,
+
+	ADCI: Immediate, ADCA: Absolute, ADCZ: ZeroPage, ADCM: MemoryIndirect,
+	SBCI: Immediate, SBCA: Absolute, SBCZ: ZeroPage, SBCM: MemoryIndirect,
+
+	ANDI: Immediate, ANDA: Absolute, ANDZ: ZeroPage, ANDM: MemoryIndirect,
+	ORI: Immediate, ORA: Absolute, ORZ: ZeroPage, ORM: MemoryIndirect,
+
+	XORI: Immediate, XORA: Absolute, XORZ: ZeroPage, XORM: MemoryIndirect,
+	CMPI: Immediate, CMPA: Absolute, CMPZ: ZeroPage, CMPM: MemoryIndirect,
+
+	BEQ: Relative, BNE: Relative, BCS: Relative, BCC: Relative,
+	BMI: Relative, BPL: Relative, BVS: Relative, BVC: Relative,
+
+	SEI: Implied, JMP: Absolute, JMPZ: ZeroPage, JSR: Absolute,
+	JSRZ: ZeroPage, RTS: Implied, INT: Implied, RTI: Implied,
+
+	CLZ: Implied, RSV1: Implied, CLC: Implied, RSV2: Implied,
+	CLN: Implied, RSV3: Implied, CLV: Implied, RSV4: Implied,
+
+	CLI: Implied, RSV5: Implied, RSV6: Implied, RSV7: Implied,
+	RSV8: Implied, PUSH: Implied, POP: Implied, HALT: Implied,
+}
@@ -14,8 +14,8 @@ func _() {
 	_ = x[ALUXOR-3]
 	_ = x[ALUNOT-4]
 	_ = x[ALUX2_-5]
-	_ = x[ALUX1_-6]
-	_ = x[ALUX0_-7]
+	_ = x[ALUASR-6]
+	_ = x[ALUROR-7]
 	_ = x[ALUADD-8]
 	_ = x[ALUSUB-9]
 	_ = x[ALUADC-10]
@@ -26,7 +26,7 @@ func _() {
 	_ = x[ALUCMP-15]
 }
 
-const _ALUMode_name = "ALUNOPALUANDALUORALUXORALUNOTALUX2_ALUX1_ALUX0_ALUADDALUSUBALUADCALUSBCALUNEGALUINCALUDECALUCMP"
+const _ALUMode_name = "ALUNOPALUANDALUORALUXORALUNOTALUX2_ALUASRALURORALUADDALUSUBALUADCALUSBCALUNEGALUINCALUDECALUCMP"
 
 var _ALUMode_index = [...]uint8{0, 6, 12, 17, 23, 29, 35, 41, 47, 53, 59, 65, 71, 77, 83, 89, 95}
 
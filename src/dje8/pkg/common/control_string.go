@@ -35,48 +35,48 @@ func _() {
 	_ = x[AU1-128]
 	_ = x[AU0-64]
 	_ = x[FL-32]
-	_ = x[EX3-16]
-	_ = x[EX2-8]
-	_ = x[EX1-4]
-	_ = x[EX0-2]
+	_ = x[FO-16]
+	_ = x[RIW-8]
+	_ = x[FM-4]
+	_ = x[CR-2]
 	_ = x[STR-1]
 }
 
-const _Control_name = "STREX0EX1EX2EX3FLAU0AU1AU2AU3PDWPDPUWPUPOWROWRORIMUWMUMIWCUWCUCOWCIHCILCIWIIBIAOAIHLT"
+const _Control_name = "STRCRFMRIWFOFLAU0AU1AU2AU3PDWPDPUWPUPOWROWRORIMUWMUMIWCUWCUCOWCIHCILCIWIIBIAOAIHLT"
 
 var _Control_map = map[Control]string{
 	1:          _Control_name[0:3],
-	2:          _Control_name[3:6],
-	4:          _Control_name[6:9],
-	8:          _Control_name[9:12],
-	16:         _Control_name[12:15],
-	32:         _Control_name[15:17],
-	64:         _Control_name[17:20],
-	128:        _Control_name[20:23],
-	256:        _Control_name[23:26],
-	512:        _Control_name[26:29],
-	1024:       _Control_name[29:32],
-	2048:       _Control_name[32:34],
-	4096:       _Control_name[34:37],
-	8192:       _Control_name[37:39],
-	16384:      _Control_name[39:42],
-	32768:      _Control_name[42:45],
-	65536:      _Control_name[45:47],
-	131072:     _Control_name[47:49],
-	262144:     _Control_name[49:52],
-	524288:     _Control_name[52:54],
-	1048576:    _Control_name[54:57],
-	2097152:    _Control_name[57:60],
-	4194304:    _Control_name[60:62],
-	8388608:    _Control_name[62:65],
-	16777216:   _Control_name[65:68],
-	33554432:   _Control_name[68:71],
-	67108864:   _Control_name[71:74],
-	134217728:  _Control_name[74:76],
-	268435456:  _Control_name[76:78],
-	536870912:  _Control_name[78:80],
-	1073741824: _Control_name[80:82],
-	2147483648: _Control_name[82:85],
+	2:          _Control_name[3:5],
+	4:          _Control_name[5:7],
+	8:          _Control_name[7:10],
+	16:         _Control_name[10:12],
+	32:         _Control_name[12:14],
+	64:         _Control_name[14:17],
+	128:        _Control_name[17:20],
+	256:        _Control_name[20:23],
+	512:        _Control_name[23:26],
+	1024:       _Control_name[26:29],
+	2048:       _Control_name[29:31],
+	4096:       _Control_name[31:34],
+	8192:       _Control_name[34:36],
+	16384:      _Control_name[36:39],
+	32768:      _Control_name[39:42],
+	65536:      _Control_name[42:44],
+	131072:     _Control_name[44:46],
+	262144:     _Control_name[46:49],
+	524288:     _Control_name[49:51],
+	1048576:    _Control_name[51:54],
+	2097152:    _Control_name[54:57],
+	4194304:    _Control_name[57:59],
+	8388608:    _Control_name[59:62],
+	16777216:   _Control_name[62:65],
+	33554432:   _Control_name[65:68],
+	67108864:   _Control_name[68:71],
+	134217728:  _Control_name[71:73],
+	268435456:  _Control_name[73:75],
+	536870912:  _Control_name[75:77],
+	1073741824: _Control_name[77:79],
+	2147483648: _Control_name[79:82],
 }
 
 func (i Control) String() string {
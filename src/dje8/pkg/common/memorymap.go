@@ -0,0 +1,33 @@
+package common
+
+// Memory Map (see SPEC.md)
+// Each range is given by its first and last (inclusive) address
+const (
+	ZeroPageStart    uint16 = 0x0000 // ZeroPage, reachable by the Z and M addressing modes
+	ZeroPageEnd      uint16 = 0x00FF
+	StackStart       uint16 = 0xB000 // Stack Pointer at reset; the stack grows down from just below the video buffer
+	VideoBufferStart uint16 = 0xB000 // Video Character Buffer (80x25 @ 8-bit color)
+	VideoBufferEnd   uint16 = 0xBFFF
+
+	IOStart              uint16 = 0xF000 // Start of the I/O region
+	Serial1Start         uint16 = 0xF000 // Serial Port 1 (UART)
+	Serial1End           uint16 = 0xF00F
+	Serial2Start         uint16 = 0xF010 // Serial Port 2 (UART)
+	Serial2End           uint16 = 0xF01F
+	KeyboardStart        uint16 = 0xF020 // Keyboard Interface
+	KeyboardEnd          uint16 = 0xF02F
+	TimerStart           uint16 = 0xF030 // Interval Timer
+	TimerEnd             uint16 = 0xF03F
+	BankSelectStart      uint16 = 0xF040 // Bank Select Registers, one per banked window
+	BankSelectEnd        uint16 = 0xF04F
+	ReservedIOStart      uint16 = 0xF050 // Reserved for other built-in devices
+	ReservedIOEnd        uint16 = 0xF3FF
+	Expansion1Start      uint16 = 0xF400 // Expansion Slot 1
+	Expansion1End        uint16 = 0xF7FF
+	Expansion2Start      uint16 = 0xF800 // Expansion Slot 2
+	Expansion2End        uint16 = 0xFBFF
+	VideoRegistersStart  uint16 = 0xFC00 // Video Controller Registers / Buffer
+	VideoRegistersEnd    uint16 = 0xFFFD
+	InterruptVectorStart uint16 = 0xFFFE // Interrupt Vector
+	InterruptVectorEnd   uint16 = 0xFFFF
+)
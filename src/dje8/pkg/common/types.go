@@ -58,11 +58,11 @@ const (
 	AU2                                       // ALU Mode Bit 2
 	AU1                                       // ALU Mode Bit 1
 	AU0                                       // ALU Mode Bit 0
-	FL                                        // Flags Register In
-	EX3                                       // Reserved
-	EX2                                       // Reserved
-	EX1                                       // Reserved
-	EX0                                       // Reserved
+	FL                                        // Flags Register In (from the ALU, or from the Data Bus when the ALU is idle)
+	FO                                        // Flags Register Out to Data Bus
+	RIW                                       // Write 2 bytes from Address Bus to memory
+	FM                                        // Flags Register Modify (flag and value decoded from the Instruction Register)
+	CR                                        // Program Counter Add signed Data Bus (relative branch)
 	STR                                       // Step Counter Reset
 	HiBitControl = HLT                        // *Const to enable traversing list
 	LoBitControl = STR                        // *Const to enable traversing list
@@ -72,8 +72,9 @@ const (
 type ALUMode uint8
 
 // The different ALU modes found in the ControlWord
-// The first 8 are logical (don't affect status flags)
-// and the second 8 are arithmetic (do affect status flags)
+// The first 8 are logical (don't affect status flags, except the right
+// shifts, which shift into the carry flag) and the second 8 are arithmetic
+// (do affect status flags)
 const (
 	ALUNOP    ALUMode  = iota // 0000 no output
 	ALUAND                    // 0001 bitwise and
@@ -81,8 +82,8 @@ const (
 	ALUXOR                    // 0011 bitwise xor
 	ALUNOT                    // 0100 bitwise inversion
 	ALUX2_                    // 0101 reserved
-	ALUX1_                    // 0110 reserved
-	ALUX0_                    // 0111 reserved
+	ALUASR                    // 0110 arithmetic shift right
+	ALUROR                    // 0111 rotate right through carry
 	ALUADD                    // 1000 add
 	ALUSUB                    // 1001 subtract
 	ALUADC                    // 1010 add considering carry flag
@@ -195,6 +196,25 @@ const (
 
 var OpCodeLookup map[string]OpCode
 
+// FlagModify is the flag an instruction changes with the FM control signal
+// and whether it is set or cleared
+type FlagModify struct {
+	Flag Flag
+	Set  bool
+}
+
+// FlagModifyLookup decodes the Instruction Register for the FM control signal
+var FlagModifyLookup = map[OpCode]FlagModify{
+	SEI: {InterruptFlagI, true},
+	CLI: {InterruptFlagI, false},
+	INT: {InterruptFlagI, true},
+	CLZ: {ZeroFlagZ, false},
+	CLC: {CarryFlagC, false},
+	CLN: {NegativeFlagN, false},
+	CLV: {OverflowFlagV, false},
+	LSR: {CarryFlagC, false},
+}
+
 func init() {
 	OpCodeLookup = make(map[string]OpCode)
 	for i := FirstOpCode; i <= LastOpCode; i++ {
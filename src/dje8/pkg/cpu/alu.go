@@ -0,0 +1,107 @@
+package cpu
+
+import (
+	//lint:ignore ST1001 importing common shared across all dje8 cmds
+	. "damien.live/dje8/pkg/common"
+)
+
+func (c *CPU) alu() {
+	var Mode ALUMode = ALUMode((c.ControlWord / AU0) & 0xf) // Getting just ALU flags in the lower half of one byte
+	c.aluFlags = c.FlagsRegister
+	switch Mode {
+	case ALUNOP:
+		// Any ALU mode other than NOP or CMP puts ALU contents on the data bus.
+		// Only arithmentic ALU modes and CMP produce new ZCNV flags, which reach
+		// the Flags Register only if FL is also asserted.
+		//
+		// This implementation of the emulator simply performs the arithmetic and computes the flags only
+		// on the cycles that the ALU bits are set even though the hardware implementation is likely
+		// always performing a computation.
+	case ALUADD:
+		c.DataBus = c.AddAndSetFlags(c.AccumulatorRegister, c.InternalRegister, false)
+	case ALUSUB:
+		c.DataBus = c.SubtractAndSetFlags(c.AccumulatorRegister, c.InternalRegister, false)
+	case ALUADC:
+		c.DataBus = c.AddAndSetFlags(c.AccumulatorRegister, c.InternalRegister, c.FlagsRegister&CarryFlagC != 0)
+	case ALUSBC:
+		c.DataBus = c.SubtractAndSetFlags(c.AccumulatorRegister, c.InternalRegister, c.FlagsRegister&CarryFlagC != 0)
+	case ALUAND:
+		c.DataBus = c.AccumulatorRegister & c.InternalRegister
+	case ALUOR:
+		c.DataBus = c.AccumulatorRegister | c.InternalRegister
+	case ALUXOR:
+		c.DataBus = c.AccumulatorRegister ^ c.InternalRegister
+	case ALUNOT:
+		c.DataBus = ^c.AccumulatorRegister
+	case ALUASR:
+		c.DataBus = uint8(int8(c.AccumulatorRegister) >> 1)
+		c.setFlag(CarryFlagC, c.AccumulatorRegister&0x01 != 0)
+		c.setZeroAndNegative(c.DataBus)
+	case ALUROR:
+		c.DataBus = c.AccumulatorRegister >> 1
+		if c.FlagsRegister&CarryFlagC != 0 {
+			c.DataBus |= 0x80
+		}
+		c.setFlag(CarryFlagC, c.AccumulatorRegister&0x01 != 0)
+		c.setZeroAndNegative(c.DataBus)
+	case ALUNEG:
+		c.DataBus = -c.AccumulatorRegister
+		c.setZeroAndNegative(c.DataBus)
+	case ALUINC:
+		c.DataBus = c.AccumulatorRegister + 1
+		c.setFlag(CarryFlagC, c.DataBus < c.AccumulatorRegister)
+		c.setFlag(OverflowFlagV, c.DataBus < c.AccumulatorRegister)
+		c.setZeroAndNegative(c.DataBus)
+	case ALUDEC:
+		c.DataBus = c.AccumulatorRegister - 1
+		c.setFlag(CarryFlagC, c.DataBus > c.AccumulatorRegister)
+		c.setFlag(OverflowFlagV, c.DataBus > c.AccumulatorRegister)
+		c.setZeroAndNegative(c.DataBus)
+	case ALUCMP:
+		_ = c.SubtractAndSetFlags(c.AccumulatorRegister, c.InternalRegister, false)
+	}
+}
+
+// AddAndSetFlags returns op1 + op2 (+ 1 if carryIn) and sets the ALU's ZCNV
+// flag outputs to match.
+func (c *CPU) AddAndSetFlags(op1 uint8, op2 uint8, carryIn bool) uint8 {
+	var result uint16 = uint16(op1) + uint16(op2)
+	if carryIn {
+		result++
+	}
+	// if the 9th bit of the result before truncation isn't 0, we carried.
+	c.setFlag(CarryFlagC, result&0x0100 != 0)
+	// the the MSb of the operands is the same and the MSb of the result is different, we overflowed the sign bit
+	c.setFlag(OverflowFlagV, op1&0x80 == op2&0x80 && op1&0x80 != uint8(result)&0x80)
+	c.setZeroAndNegative(uint8(result))
+
+	return uint8(result)
+}
+
+// SubtractAndSetFlags returns op1 - op2 (- 1 if carryIn) and sets the ALU's
+// ZCNV flag outputs to match.  The carry flag is set on borrow.
+func (c *CPU) SubtractAndSetFlags(op1 uint8, op2 uint8, carryIn bool) uint8 {
+	var result uint8 = op1 - op2
+	if carryIn {
+		result--
+	}
+	c.setFlag(CarryFlagC, ((^op1&op2)|(^(op1^op2)&result))>>7 != 0)
+	// the the MSb of the operands differ and the MSb of the result differs from the first operand, we overflowed the sign bit
+	c.setFlag(OverflowFlagV, op1&0x80 != op2&0x80 && op1&0x80 != result&0x80)
+	c.setZeroAndNegative(result)
+
+	return result
+}
+
+func (c *CPU) setZeroAndNegative(value uint8) {
+	c.setFlag(ZeroFlagZ, value == 0)
+	c.setFlag(NegativeFlagN, value&0x80 != 0)
+}
+
+func (c *CPU) setFlag(f Flag, on bool) {
+	if on {
+		c.aluFlags |= f
+	} else {
+		c.aluFlags &= (^f)
+	}
+}
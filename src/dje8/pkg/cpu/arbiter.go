@@ -0,0 +1,46 @@
+package cpu
+
+import "damien.live/dje8/pkg/bus"
+
+// CPUHoldsBus is the value of BusGrant while no DMA slot has the bus
+const CPUHoldsBus = -1
+
+// BusMaster is a DMA capable device plugged into one of the CPU's
+// prioritized DMA slots.
+type BusMaster interface {
+	// BusRequest reports whether the device is asserting its DMA_REQ line
+	BusRequest() bool
+	// BusCycle is called once per clock while the device holds DMA_GNT.
+	// The device drives the buses for that clock and may make one access
+	// to b.
+	BusCycle(b bus.Bus)
+}
+
+// AttachDMA plugs master into the next DMA slot.  Slots attached earlier
+// have priority over later ones.
+func (c *CPU) AttachDMA(master BusMaster) {
+	c.dmaSlots = append(c.dmaSlots, master)
+}
+
+// arbitrate is the bus arbiter.  It raises BUS_REQ while any slot asserts
+// DMA_REQ and the CPU answers with BUS_ACK only at an instruction boundary
+// (before step 0), when it holds nothing on the buses.  The bus stays with
+// the granted slot until it drops its request, then passes to the highest
+// priority slot still requesting, or back to the CPU.  It reports whether a
+// slot holds the bus for this clock.
+func (c *CPU) arbitrate() bool {
+	if c.ClockPulse != 0 {
+		return false
+	}
+	if c.BusGrant != CPUHoldsBus && c.dmaSlots[c.BusGrant].BusRequest() {
+		return true
+	}
+	c.BusGrant = CPUHoldsBus
+	for slot, master := range c.dmaSlots {
+		if master.BusRequest() {
+			c.BusGrant = slot
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,339 @@
+package cpu
+
+import (
+	"context"
+
+	"damien.live/dje8/pkg/bus"
+	//lint:ignore ST1001 importing common shared across all dje8 cmds
+	. "damien.live/dje8/pkg/common"
+)
+
+// CPU holds the complete state of one DJE-8 processor: its registers, its
+// buses, the memory and devices it is attached to and the Control ROM that
+// drives it.
+// Several CPUs can exist side by side in one process.
+type CPU struct {
+	// registers
+	ProgramCounter        uint16
+	MemoryAddressRegister uint16
+	StackPointer          uint16
+	InstructionRegister   uint8
+	AccumulatorRegister   uint8
+	InternalRegister      uint8
+	FlagsRegister         Flag
+	ControlWord           Control
+	ClockPulse            uint8
+
+	AddressBus uint16 // address bus is pulled up to the interrupt vector (0xFFFE) when inactive
+	DataBus    uint8  // data bus is pulled high when inactive (can be used as a source of -1)
+	Bus        bus.Bus
+	ROMAddress uint16
+	ControlROM []Control
+
+	Halted    bool
+	Cycles    uint64 // number of clocks since the last Reset, including those given to DMA
+	DMACycles uint64 // number of clocks the bus was held by a DMA slot since the last Reset
+	BusGrant  int    // DMA slot holding the bus, or CPUHoldsBus
+
+	// BeforeStep, when set, is called on every microstep after the control
+	// word has been looked up and before any signal is acted on.
+	BeforeStep func(c *CPU)
+	// MemoryWatch, when set, is called on every read from and write to the
+	// Bus made while executing.
+	MemoryWatch func(c *CPU, address uint16, value uint8, write bool)
+
+	aluFlags     Flag // flag outputs of the ALU, latched into FlagsRegister by FL
+	interrupting bool // an interrupt was accepted at the start of this instruction
+	irqSources   []InterruptSource
+	clocked      []Ticker
+	dmaSlots     []BusMaster
+}
+
+// InterruptSource is a device that can assert the IRQ line.  The line is
+// asserted while any attached source's IRQ returns true.
+type InterruptSource interface {
+	IRQ() bool
+}
+
+// Ticker is a device clocked along with the CPU, once per microstep
+type Ticker interface {
+	Tick()
+}
+
+// AttachIRQ connects source to the IRQ line
+func (c *CPU) AttachIRQ(source InterruptSource) {
+	c.irqSources = append(c.irqSources, source)
+}
+
+// AttachClock connects device to the CPU clock
+func (c *CPU) AttachClock(device Ticker) {
+	c.clocked = append(c.clocked, device)
+}
+
+// IRQ reports whether the IRQ line is asserted
+func (c *CPU) IRQ() bool {
+	for _, source := range c.irqSources {
+		if source.IRQ() {
+			return true
+		}
+	}
+	return false
+}
+
+// New returns a CPU driven by controlROM and attached to b.  If b is nil a
+// bus.NewStandard memory map is created.
+func New(controlROM []Control, b bus.Bus) *CPU {
+	if b == nil {
+		b = bus.NewStandard()
+	}
+	c := &CPU{Bus: b, ControlROM: controlROM}
+	c.Reset()
+	return c
+}
+
+// Reset puts every register back to its power-on state.  Memory is left
+// untouched.  The CPU comes out of reset with interrupts disabled.
+func (c *CPU) Reset() {
+	c.ProgramCounter = 0
+	c.MemoryAddressRegister = 0
+	c.StackPointer = StackStart
+	c.InstructionRegister = 0
+	c.AccumulatorRegister = 0
+	c.InternalRegister = 0
+	c.FlagsRegister = InterruptFlagI
+	c.ControlWord = 0
+	c.ClockPulse = 0
+	c.AddressBus = 0
+	c.DataBus = 0xff
+	c.ROMAddress = 0
+	c.Halted = false
+	c.Cycles = 0
+	c.DMACycles = 0
+	c.BusGrant = CPUHoldsBus
+	c.interrupting = false
+}
+
+// Run steps the CPU until it halts or ctx is done.  It returns nil on HLT
+// and ctx.Err() on cancellation.
+func (c *CPU) Run(ctx context.Context) error {
+	for !c.Halted {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+		c.Step()
+	}
+	return nil
+}
+
+// StepInstruction steps the CPU until the current instruction completes,
+// i.e. until the step counter is back at 0, or the CPU halts.
+func (c *CPU) StepInstruction() {
+	c.Step()
+	for c.ClockPulse != 0 && !c.Halted {
+		c.Step()
+	}
+}
+
+// Step executes a single microstep: the control word for the current step
+// counter value is looked up and acted on and the step counter advances.
+// While a DMA slot holds the bus the clock goes to it instead and the CPU
+// does not advance.
+func (c *CPU) Step() {
+	if c.Halted {
+		return
+	}
+	if c.arbitrate() {
+		// the CPU waits out the clock while the DMA slot drives the buses
+		c.Cycles++
+		c.DMACycles++
+		c.dmaSlots[c.BusGrant].BusCycle(c.Bus)
+		c.tick()
+		return
+	}
+	c.ControlWord = c.ControlROMLookup(c.ClockPulse)
+	if c.BeforeStep != nil {
+		c.BeforeStep(c)
+	}
+	c.Cycles++
+
+	switch c.ClockPulse {
+	case 0: // FETCH
+		// interrupts are only accepted between instructions
+		c.interrupting = c.FlagsRegister&InterruptFlagI == 0 && c.IRQ()
+		c.AddressBus = c.ProgramCounter        // CO
+		c.MemoryAddressRegister = c.AddressBus // MI
+	case 1: // DECODE
+		if c.interrupting {
+			// the interrupt logic jams INT into the Instruction Register in
+			// place of the next opcode, which is left to be fetched after RTI
+			c.InstructionRegister = uint8(INT)
+			break
+		}
+		c.DataBus = c.read(c.MemoryAddressRegister) // RO
+		c.InstructionRegister = c.DataBus           // II
+		c.ProgramCounter++                          // CU
+	default: // EXECUTE
+		if c.ControlWord&HLT != 0 {
+			c.Halted = true
+			return
+		}
+		c.execute()
+	}
+	c.tick()
+	// End instruction cycle last
+	if c.ClockPulse > 1 && c.ControlWord&STR != 0 {
+		c.ClockPulse = 0
+		return
+	}
+	c.ClockPulse = (c.ClockPulse + 1) % 16
+}
+
+func (c *CPU) tick() {
+	for _, device := range c.clocked {
+		device.Tick()
+	}
+}
+
+func (c *CPU) execute() {
+	// Inactive buses float to their pulled up values
+	c.AddressBus = InterruptVectorStart
+	c.DataBus = 0xff
+
+	// ***** OUT SIGNALS FIRST *****
+	// Address Bus OUT Signals
+	if c.ControlWord&COW != 0 {
+		c.AddressBus = c.ProgramCounter
+	}
+	if c.ControlWord&POW != 0 {
+		c.AddressBus = c.StackPointer
+	}
+	if c.ControlWord&ROW != 0 { // addresses are stored LSB first
+		c.AddressBus = uint16(c.read(c.MemoryAddressRegister)) | uint16(c.read(c.MemoryAddressRegister+1))<<8
+	}
+
+	// Data Bus OUT Signals
+	if c.ControlWord&AO != 0 {
+		c.DataBus = c.AccumulatorRegister
+	}
+	if c.ControlWord&RO != 0 {
+		c.DataBus = c.read(c.MemoryAddressRegister)
+	}
+	if c.ControlWord&FO != 0 {
+		c.DataBus = uint8(c.FlagsRegister)
+	}
+	c.alu()
+
+	// ***** IN SIGNALS NEXT *****
+	// Address Bus IN Signals
+	if c.ControlWord&CIW != 0 {
+		c.ProgramCounter = c.AddressBus
+	}
+	if c.ControlWord&MIW != 0 {
+		c.MemoryAddressRegister = c.AddressBus
+	}
+	if c.ControlWord&RI != 0 {
+		c.write(c.MemoryAddressRegister, c.DataBus)
+	}
+	if c.ControlWord&RIW != 0 { // addresses are stored LSB first
+		c.write(c.MemoryAddressRegister, uint8(c.AddressBus))
+		c.write(c.MemoryAddressRegister+1, uint8(c.AddressBus>>8))
+	}
+
+	// Data Bus IN Signals
+	if c.ControlWord&AI != 0 {
+		c.AccumulatorRegister = c.DataBus
+	}
+	if c.ControlWord&BI != 0 {
+		c.InternalRegister = c.DataBus
+	}
+	if c.ControlWord&CIH != 0 {
+		c.ProgramCounter = uint16(c.DataBus)<<8 | (c.ProgramCounter & 0x00ff)
+	}
+	if c.ControlWord&CIL != 0 {
+		c.ProgramCounter = uint16(c.DataBus) | (c.ProgramCounter & 0xff00)
+	}
+	if c.ControlWord&II != 0 {
+		c.InstructionRegister = c.DataBus
+	}
+
+	// Flags
+	if c.ControlWord&FL != 0 {
+		if ALUMode((c.ControlWord/AU0)&0xf) == ALUNOP {
+			c.FlagsRegister = Flag(c.DataBus)
+		} else {
+			c.FlagsRegister = c.aluFlags
+		}
+	}
+	if c.ControlWord&FM != 0 {
+		if modify, found := FlagModifyLookup[OpCode(c.InstructionRegister)]; found {
+			if modify.Set {
+				c.FlagsRegister |= modify.Flag
+			} else {
+				c.FlagsRegister &= (^modify.Flag)
+			}
+		}
+	}
+
+	// Increments and decrements
+	if c.ControlWord&CR != 0 { // the offset is signed
+		c.ProgramCounter += uint16(int8(c.DataBus))
+	}
+	if c.ControlWord&CU != 0 {
+		c.ProgramCounter++
+	}
+	if c.ControlWord&CUW != 0 {
+		c.ProgramCounter += 2
+	}
+	if c.ControlWord&MU != 0 {
+		c.MemoryAddressRegister++
+	}
+	if c.ControlWord&MUW != 0 {
+		c.MemoryAddressRegister += 2
+	}
+	if c.ControlWord&PU != 0 {
+		c.StackPointer++
+	}
+	if c.ControlWord&PUW != 0 {
+		c.StackPointer += 2
+	}
+	if c.ControlWord&PD != 0 {
+		c.StackPointer--
+	}
+	if c.ControlWord&PDW != 0 {
+		c.StackPointer -= 2
+	}
+}
+
+func (c *CPU) read(address uint16) uint8 {
+	value := c.Bus.Read8(address)
+	if c.MemoryWatch != nil {
+		c.MemoryWatch(c, address, value, false)
+	}
+	return value
+}
+
+func (c *CPU) write(address uint16, value uint8) {
+	c.Bus.Write8(address, value)
+	if c.MemoryWatch != nil {
+		c.MemoryWatch(c, address, value, true)
+	}
+}
+
+// ControlROMLookup returns the control word for microStep of the instruction
+// currently held in the Instruction Register.
+func (c *CPU) ControlROMLookup(microStep uint8) Control {
+	// Control ROM Address calc:
+	// Z C N V OPCODEXX STEP
+	// where:
+	//	Z = zero flag
+	//	C = carry flag
+	//	N = negative flag
+	//  V = overflow flag
+	//  OPCODEXX = current 8-bit instruction op code
+	//  STEP = current 4-bit microcode step
+
+	c.ROMAddress = (uint16(c.InstructionRegister))<<4 | ((uint16(c.FlagsRegister))&0xf)<<12 | (uint16(microStep))
+	return Control(c.ControlROM[c.ROMAddress])
+}
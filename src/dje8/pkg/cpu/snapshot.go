@@ -0,0 +1,41 @@
+package cpu
+
+import (
+	//lint:ignore ST1001 importing common shared across all dje8 cmds
+	. "damien.live/dje8/pkg/common"
+)
+
+// Snapshot is a copy of the CPU registers at a point in time, suitable for
+// reporting (e.g. as JSON) once a run has finished.
+type Snapshot struct {
+	ProgramCounter        uint16 `json:"pc"`
+	MemoryAddressRegister uint16 `json:"mar"`
+	StackPointer          uint16 `json:"sp"`
+	InstructionRegister   uint8  `json:"ir"`
+	Instruction           string `json:"instruction"`
+	AccumulatorRegister   uint8  `json:"a"`
+	InternalRegister      uint8  `json:"b"`
+	FlagsRegister         uint8  `json:"flags"`
+	ClockPulse            uint8  `json:"step"`
+	Halted                bool   `json:"halted"`
+	Cycles                uint64 `json:"cycles"`
+	DMACycles             uint64 `json:"dma_cycles"`
+}
+
+// Snapshot returns a copy of the current register values.
+func (c *CPU) Snapshot() Snapshot {
+	return Snapshot{
+		ProgramCounter:        c.ProgramCounter,
+		MemoryAddressRegister: c.MemoryAddressRegister,
+		StackPointer:          c.StackPointer,
+		InstructionRegister:   c.InstructionRegister,
+		Instruction:           OpCode(c.InstructionRegister).String(),
+		AccumulatorRegister:   c.AccumulatorRegister,
+		InternalRegister:      c.InternalRegister,
+		FlagsRegister:         uint8(c.FlagsRegister),
+		ClockPulse:            c.ClockPulse,
+		Halted:                c.Halted,
+		Cycles:                c.Cycles,
+		DMACycles:             c.DMACycles,
+	}
+}
@@ -0,0 +1,320 @@
+package debugger
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	//lint:ignore ST1001 importing common shared across all dje8 cmds
+	. "damien.live/dje8/pkg/common"
+	"damien.live/dje8/pkg/cpu"
+)
+
+// WatchKind selects which memory accesses trigger a watchpoint
+type WatchKind uint8
+
+const (
+	WatchRead WatchKind = 1 << iota
+	WatchWrite
+)
+
+// Condition is a break condition checked at every instruction boundary
+type Condition struct {
+	Text string
+	test func(c *cpu.CPU) bool
+}
+
+// Debugger drives a CPU one microstep or instruction at a time and stops it
+// on breakpoints, watchpoints and conditions.
+type Debugger struct {
+	CPU     *cpu.CPU
+	Symbols map[string]uint16
+
+	breakpoints map[uint16]bool
+	watchpoints map[uint16]WatchKind
+	conditions  []Condition
+
+	hit         string // reason to stop raised during the last microstep
+	interrupted atomic.Bool
+}
+
+// New attaches a debugger to c.  The CPU's MemoryWatch hook is taken over
+// by the debugger.
+func New(c *cpu.CPU) *Debugger {
+	d := &Debugger{
+		CPU:         c,
+		Symbols:     make(map[string]uint16),
+		breakpoints: make(map[uint16]bool),
+		watchpoints: make(map[uint16]WatchKind),
+	}
+	c.MemoryWatch = d.memoryWatch
+	return d
+}
+
+// LoadSymbols reads a symbol file as written by asm -l: one "label address"
+// pair per line.
+func LoadSymbols(r io.Reader) (map[string]uint16, error) {
+	symbols := make(map[string]uint16)
+	scanner := bufio.NewScanner(r)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("malformed symbol on line %d: %q", lineNo, scanner.Text())
+		}
+		address, err := strconv.ParseUint(fields[1], 0, 16)
+		if err != nil {
+			return nil, fmt.Errorf("bad address for %s on line %d: %v", fields[0], lineNo, err)
+		}
+		symbols[fields[0]] = uint16(address)
+	}
+	return symbols, scanner.Err()
+}
+
+// Resolve turns a number or a label, optionally followed by +N or -N, into
+// an address
+func (d *Debugger) Resolve(s string) (uint16, error) {
+	if address, err := strconv.ParseUint(s, 0, 16); err == nil {
+		return uint16(address), nil
+	}
+	name, offset := s, int64(0)
+	if idx := strings.LastIndexAny(s, "+-"); idx > 0 {
+		var err error
+		if offset, err = strconv.ParseInt(s[idx:], 0, 17); err != nil {
+			return 0, fmt.Errorf("bad offset in %s: %v", s, err)
+		}
+		name = s[:idx]
+	}
+	address, found := d.Symbols[name]
+	if !found {
+		return 0, fmt.Errorf("unknown address or symbol %s", name)
+	}
+	return address + uint16(offset), nil
+}
+
+// SymbolFor names address relative to the closest symbol at or below it,
+// e.g. "loop+2".  It returns "" if there is no such symbol within a page.
+func (d *Debugger) SymbolFor(address uint16) string {
+	best, bestAddress := "", uint16(0)
+	for _, name := range slices.Sorted(maps.Keys(d.Symbols)) {
+		symAddress := d.Symbols[name]
+		if symAddress <= address && address-symAddress < 256 && (best == "" || symAddress > bestAddress) {
+			best, bestAddress = name, symAddress
+		}
+	}
+	if best == "" || address == bestAddress {
+		return best
+	}
+	return fmt.Sprintf("%s+%d", best, address-bestAddress)
+}
+
+// Break sets a breakpoint that stops execution before the instruction at
+// address is fetched
+func (d *Debugger) Break(address uint16) {
+	d.breakpoints[address] = true
+}
+
+// Watch sets a watchpoint on a single memory location
+func (d *Debugger) Watch(address uint16, kind WatchKind) {
+	d.watchpoints[address] |= kind
+}
+
+// Delete removes any breakpoint and watchpoint at address
+func (d *Debugger) Delete(address uint16) bool {
+	_, isBreak := d.breakpoints[address]
+	_, isWatch := d.watchpoints[address]
+	delete(d.breakpoints, address)
+	delete(d.watchpoints, address)
+	return isBreak || isWatch
+}
+
+// ParseCondition compiles a break condition.  Accepted forms are a flag
+// name (Z, C, N, V or I) optionally preceded by ! for "clear", or a
+// comparison of a register (a, b, pc, sp, mar or flags) with a number using
+// one of == != < <= > >=.
+func ParseCondition(text string) (Condition, error) {
+	text = strings.TrimSpace(text)
+	negate := strings.HasPrefix(text, "!")
+	flagName := strings.ToUpper(strings.TrimPrefix(text, "!"))
+	for f := HiBitFlag; f >= LoBitFlag; f = f >> 1 {
+		if name := f.String(); flagName == name[len(name)-1:] && !strings.HasSuffix(name, "_") {
+			return Condition{text, func(c *cpu.CPU) bool {
+				return (c.FlagsRegister&f != 0) != negate
+			}}, nil
+		}
+	}
+
+	for _, op := range []string{"==", "!=", "<=", ">=", "<", ">"} {
+		regName, valueStr, found := strings.Cut(text, op)
+		if !found {
+			continue
+		}
+		register, err := registerGetter(strings.TrimSpace(regName))
+		if err != nil {
+			return Condition{}, err
+		}
+		value, err := strconv.ParseUint(strings.TrimSpace(valueStr), 0, 16)
+		if err != nil {
+			return Condition{}, fmt.Errorf("bad value in condition %s: %v", text, err)
+		}
+		compare := map[string]func(a, b uint64) bool{
+			"==": func(a, b uint64) bool { return a == b },
+			"!=": func(a, b uint64) bool { return a != b },
+			"<=": func(a, b uint64) bool { return a <= b },
+			">=": func(a, b uint64) bool { return a >= b },
+			"<":  func(a, b uint64) bool { return a < b },
+			">":  func(a, b uint64) bool { return a > b },
+		}[op]
+		return Condition{text, func(c *cpu.CPU) bool {
+			return compare(register(c), value)
+		}}, nil
+	}
+	return Condition{}, fmt.Errorf("cannot understand condition %s", text)
+}
+
+// AddCondition adds a break condition checked at every instruction boundary
+func (d *Debugger) AddCondition(cond Condition) {
+	d.conditions = append(d.conditions, cond)
+}
+
+// Interrupt asks a running Continue to stop at the next microstep.  It is
+// safe to call from another goroutine, e.g. a signal handler.
+func (d *Debugger) Interrupt() {
+	d.interrupted.Store(true)
+}
+
+// Continue runs until a breakpoint, watchpoint or condition triggers, the
+// CPU halts or Interrupt is called, and returns the reason it stopped.
+// Breakpoints are only tested once the CPU has taken a clock, so the one
+// it starts at is passed over even while DMA holds the bus.
+func (d *Debugger) Continue() string {
+	d.interrupted.Store(false)
+	executed := false
+	for {
+		cpuCycles := d.CPU.Cycles - d.CPU.DMACycles
+		if reason := d.step(); reason != "" {
+			return reason
+		}
+		executed = executed || d.CPU.Cycles-d.CPU.DMACycles != cpuCycles
+		if d.interrupted.Load() {
+			return "interrupted"
+		}
+		if d.CPU.ClockPulse != 0 {
+			continue
+		}
+		if d.breakpoints[d.CPU.ProgramCounter] && executed {
+			return fmt.Sprintf("breakpoint at %s", d.formatAddress(d.CPU.ProgramCounter))
+		}
+		for _, cond := range d.conditions {
+			if cond.test(d.CPU) {
+				return fmt.Sprintf("condition %s holds", cond.Text)
+			}
+		}
+	}
+}
+
+// StepMicro executes up to n microsteps, stopping early on a watchpoint or
+// HALT, and returns the reason it stopped early if it did.
+func (d *Debugger) StepMicro(n int) string {
+	for range n {
+		if reason := d.step(); reason != "" {
+			return reason
+		}
+	}
+	return ""
+}
+
+// StepInstructions executes up to n instructions, stopping early on a
+// watchpoint or HALT, and returns the reason it stopped early if it did.
+func (d *Debugger) StepInstructions(n int) string {
+	for range n {
+		if reason := d.step(); reason != "" {
+			return reason
+		}
+		for d.CPU.ClockPulse != 0 {
+			if reason := d.step(); reason != "" {
+				return reason
+			}
+		}
+	}
+	return ""
+}
+
+func (d *Debugger) step() string {
+	if d.CPU.Halted {
+		return "halted"
+	}
+	d.hit = ""
+	d.CPU.Step()
+	if d.hit != "" {
+		return d.hit
+	}
+	if d.CPU.Halted {
+		return "halted"
+	}
+	return ""
+}
+
+func (d *Debugger) memoryWatch(c *cpu.CPU, address uint16, value uint8, write bool) {
+	kind := d.watchpoints[address]
+	if write && kind&WatchWrite != 0 {
+		d.hit = fmt.Sprintf("watchpoint: wrote 0x%02x to %s", value, d.formatAddress(address))
+	} else if !write && kind&WatchRead != 0 {
+		d.hit = fmt.Sprintf("watchpoint: read 0x%02x from %s", value, d.formatAddress(address))
+	}
+}
+
+func (d *Debugger) formatAddress(address uint16) string {
+	if name := d.SymbolFor(address); name != "" {
+		return fmt.Sprintf("0x%04x <%s>", address, name)
+	}
+	return fmt.Sprintf("0x%04x", address)
+}
+
+func registerGetter(name string) (func(c *cpu.CPU) uint64, error) {
+	switch strings.ToLower(name) {
+	case "a":
+		return func(c *cpu.CPU) uint64 { return uint64(c.AccumulatorRegister) }, nil
+	case "b":
+		return func(c *cpu.CPU) uint64 { return uint64(c.InternalRegister) }, nil
+	case "pc":
+		return func(c *cpu.CPU) uint64 { return uint64(c.ProgramCounter) }, nil
+	case "sp":
+		return func(c *cpu.CPU) uint64 { return uint64(c.StackPointer) }, nil
+	case "mar":
+		return func(c *cpu.CPU) uint64 { return uint64(c.MemoryAddressRegister) }, nil
+	case "flags", "f":
+		return func(c *cpu.CPU) uint64 { return uint64(c.FlagsRegister) }, nil
+	}
+	return nil, fmt.Errorf("unknown register %s", name)
+}
+
+// setRegister pokes value into the named register
+func setRegister(c *cpu.CPU, name string, value uint64) error {
+	switch strings.ToLower(name) {
+	case "a":
+		c.AccumulatorRegister = uint8(value)
+	case "b":
+		c.InternalRegister = uint8(value)
+	case "pc":
+		c.ProgramCounter = uint16(value)
+	case "sp":
+		c.StackPointer = uint16(value)
+	case "mar":
+		c.MemoryAddressRegister = uint16(value)
+	case "flags", "f":
+		c.FlagsRegister = Flag(value)
+	default:
+		return fmt.Errorf("unknown register %s", name)
+	}
+	return nil
+}
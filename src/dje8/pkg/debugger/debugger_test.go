@@ -0,0 +1,147 @@
+package debugger_test
+
+import (
+	"strings"
+	"testing"
+
+	"damien.live/dje8/pkg/asm"
+	"damien.live/dje8/pkg/bus"
+	"damien.live/dje8/pkg/cpu"
+	"damien.live/dje8/pkg/debugger"
+	"damien.live/dje8/pkg/ucodebuilder"
+)
+
+// source counts A up from 0 at loop until it is 3, then halts
+const source = `
+#org 0x8000
+start:  LODI 0
+loop:   ADDI 1
+        STOA 0x9000
+        CMPI 3
+        BNE loop
+        LODA 0x9000
+end:    HALT
+`
+
+// newDebugger returns a debugger for a CPU with source loaded, at start
+func newDebugger(t *testing.T) *debugger.Debugger {
+	program, diagnostics := asm.Assemble(strings.NewReader(source), asm.Options{Filename: "test.asm"})
+	if len(diagnostics) > 0 {
+		t.Fatal(diagnostics)
+	}
+	CPU := cpu.New(ucodebuilder.BuildUcode(), nil)
+	for i, value := range program.Bytes {
+		CPU.Bus.Write8(program.Origin+uint16(i), value)
+	}
+	CPU.ProgramCounter = program.Origin
+	d := debugger.New(CPU)
+	d.Symbols = program.Symbols
+	return d
+}
+
+// continueTo runs Continue and checks why it stopped and the value of A
+func continueTo(t *testing.T, d *debugger.Debugger, wantReason string, wantA uint8) {
+	t.Helper()
+	if reason := d.Continue(); reason != wantReason {
+		t.Errorf("Continue() = %q, want %q", reason, wantReason)
+	}
+	if d.CPU.AccumulatorRegister != wantA {
+		t.Errorf("A = %d, want %d", d.CPU.AccumulatorRegister, wantA)
+	}
+}
+
+func TestBreakpoint(t *testing.T) {
+	d := newDebugger(t)
+	d.Break(d.Symbols["loop"])
+	continueTo(t, d, "breakpoint at 0x8002 <loop>", 0)
+	// resuming passes over the breakpoint it stopped at, until the loop
+	// comes back round to it
+	continueTo(t, d, "breakpoint at 0x8002 <loop>", 1)
+	continueTo(t, d, "breakpoint at 0x8002 <loop>", 2)
+	continueTo(t, d, "halted", 3)
+}
+
+func TestBreakpointMidInstruction(t *testing.T) {
+	d := newDebugger(t)
+	d.Break(d.Symbols["loop"])
+	d.StepMicro(3) // all but the last step of LODI 0
+	continueTo(t, d, "breakpoint at 0x8002 <loop>", 0)
+}
+
+// dma holds the bus for the number of clocks in requests
+type dma struct{ requests int }
+
+func (m *dma) BusRequest() bool   { return m.requests > 0 }
+func (m *dma) BusCycle(b bus.Bus) { m.requests-- }
+
+func TestBreakpointWhileDMAHoldsTheBus(t *testing.T) {
+	d := newDebugger(t)
+	d.Break(d.Symbols["loop"])
+	continueTo(t, d, "breakpoint at 0x8002 <loop>", 0)
+	d.CPU.AttachDMA(&dma{requests: 5})
+	continueTo(t, d, "breakpoint at 0x8002 <loop>", 1)
+	if d.CPU.DMACycles != 5 {
+		t.Errorf("DMACycles = %d, want 5", d.CPU.DMACycles)
+	}
+}
+
+func TestWatchpoint(t *testing.T) {
+	d := newDebugger(t)
+	d.Watch(0x9000, debugger.WatchWrite)
+	continueTo(t, d, "watchpoint: wrote 0x01 to 0x9000", 1)
+	continueTo(t, d, "watchpoint: wrote 0x02 to 0x9000", 2)
+
+	d = newDebugger(t)
+	d.Watch(0x9000, debugger.WatchRead)
+	continueTo(t, d, "watchpoint: read 0x03 from 0x9000", 3)
+}
+
+func TestCondition(t *testing.T) {
+	for _, c := range []struct {
+		text   string
+		wantA  uint8
+		repeat bool
+	}{
+		// a stays 2 until the next ADDI, so Continue stops again after each
+		// instruction it holds over
+		{"a==2", 2, true},
+		// Z is only set once CMPI 3 matches
+		{"Z", 3, false},
+	} {
+		t.Run(c.text, func(t *testing.T) {
+			d := newDebugger(t)
+			cond, err := debugger.ParseCondition(c.text)
+			if err != nil {
+				t.Fatal(err)
+			}
+			d.AddCondition(cond)
+			continueTo(t, d, "condition "+c.text+" holds", c.wantA)
+			if c.repeat {
+				continueTo(t, d, "condition "+c.text+" holds", c.wantA)
+			}
+		})
+	}
+}
+
+func TestParseCondition(t *testing.T) {
+	for _, c := range []struct {
+		text string
+		err  string
+	}{
+		{"!C", ""},
+		{"pc >= 0x8000", ""},
+		{"q == 1", "unknown register q"},
+		{"a == x", `bad value in condition a == x: strconv.ParseUint: parsing "x": invalid syntax`},
+		{"a", "cannot understand condition a"},
+	} {
+		_, err := debugger.ParseCondition(c.text)
+		if got := ""; err != nil {
+			got = err.Error()
+			if got != c.err {
+				t.Errorf("ParseCondition(%q) error %q, want %q", c.text, got, c.err)
+			}
+		} else if c.err != "" {
+			t.Errorf("ParseCondition(%q) succeeded, want error %q", c.text, c.err)
+		}
+	}
+}
@@ -0,0 +1,258 @@
+package debugger
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+
+	"damien.live/dje8/pkg/bus"
+	//lint:ignore ST1001 importing common shared across all dje8 cmds
+	. "damien.live/dje8/pkg/common"
+)
+
+const helpText = `s, step [n]               execute n microsteps (default 1)
+n, next [n]               execute n whole instructions (default 1)
+c, continue               run until a breakpoint, watchpoint, condition or HALT
+b, break <addr>           stop before the instruction at addr is fetched
+bc, cond <expr>           stop at an instruction boundary when expr holds,
+                          e.g. Z, !C, a == 0x10, pc >= 0x8100
+w, watch <addr> [r|w|rw]  stop on a memory read and/or write (default w)
+d, delete <addr|#n>       remove the break/watchpoint at addr or condition n
+i, info                   list breakpoints, watchpoints and conditions
+r, regs                   show registers
+x <addr> [len]            examine memory (default 16 bytes)
+set <reg|addr> <value>    poke a register (a b pc sp mar flags) or memory byte
+sym                       list symbols
+map                       show the memory map
+reset                     reset the registers (memory is kept)
+q, quit                   leave the debugger
+h, help                   show this text
+Addresses may be numbers or symbols with an optional offset, e.g. loop+2.
+An empty line repeats the previous command.
+`
+
+// REPL reads debugger commands from in until quit or end of input and
+// writes the results to out.
+func (d *Debugger) REPL(in io.Reader, out io.Writer) error {
+	scanner := bufio.NewScanner(in)
+	last := ""
+	d.printState(out)
+	for {
+		fmt.Fprint(out, "(dje8) ")
+		if !scanner.Scan() {
+			fmt.Fprintln(out)
+			return scanner.Err()
+		}
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			line = last
+		}
+		last = line
+		if line == "" {
+			continue
+		}
+		if quit := d.execute(line, out); quit {
+			return nil
+		}
+	}
+}
+
+func (d *Debugger) execute(line string, out io.Writer) (quit bool) {
+	fields := strings.Fields(line)
+	command, args := fields[0], fields[1:]
+	count := func() int {
+		if len(args) > 0 {
+			if n, err := strconv.Atoi(args[0]); err == nil && n > 0 {
+				return n
+			}
+		}
+		return 1
+	}
+
+	switch command {
+	case "s", "step":
+		d.report(out, d.StepMicro(count()))
+	case "n", "next":
+		d.report(out, d.StepInstructions(count()))
+	case "c", "continue":
+		d.report(out, d.Continue())
+	case "b", "break":
+		if address, ok := d.addressArg(out, args); ok {
+			d.Break(address)
+			fmt.Fprintf(out, "breakpoint at %s\n", d.formatAddress(address))
+		}
+	case "bc", "cond":
+		cond, err := ParseCondition(strings.Join(args, " "))
+		if err != nil {
+			fmt.Fprintln(out, err)
+			break
+		}
+		d.AddCondition(cond)
+		fmt.Fprintf(out, "condition #%d: %s\n", len(d.conditions), cond.Text)
+	case "w", "watch":
+		address, ok := d.addressArg(out, args)
+		if !ok {
+			break
+		}
+		kind := WatchWrite
+		if len(args) > 1 {
+			switch args[1] {
+			case "r":
+				kind = WatchRead
+			case "w":
+				kind = WatchWrite
+			case "rw", "wr":
+				kind = WatchRead | WatchWrite
+			default:
+				fmt.Fprintf(out, "unknown watch kind %s, expected r, w or rw\n", args[1])
+				return false
+			}
+		}
+		d.Watch(address, kind)
+		fmt.Fprintf(out, "watchpoint at %s\n", d.formatAddress(address))
+	case "d", "delete":
+		if len(args) > 0 && strings.HasPrefix(args[0], "#") {
+			n, err := strconv.Atoi(args[0][1:])
+			if err != nil || n < 1 || n > len(d.conditions) {
+				fmt.Fprintf(out, "no condition %s\n", args[0])
+				break
+			}
+			d.conditions = slices.Delete(d.conditions, n-1, n)
+			break
+		}
+		if address, ok := d.addressArg(out, args); ok && !d.Delete(address) {
+			fmt.Fprintf(out, "nothing set at %s\n", d.formatAddress(address))
+		}
+	case "i", "info":
+		for _, address := range slices.Sorted(maps.Keys(d.breakpoints)) {
+			fmt.Fprintf(out, "break  %s\n", d.formatAddress(address))
+		}
+		for _, address := range slices.Sorted(maps.Keys(d.watchpoints)) {
+			kind := map[WatchKind]string{WatchRead: "r", WatchWrite: "w", WatchRead | WatchWrite: "rw"}[d.watchpoints[address]]
+			fmt.Fprintf(out, "watch  %s %s\n", d.formatAddress(address), kind)
+		}
+		for i, cond := range d.conditions {
+			fmt.Fprintf(out, "cond   #%d %s\n", i+1, cond.Text)
+		}
+	case "r", "regs":
+		d.printState(out)
+	case "x":
+		address, ok := d.addressArg(out, args)
+		if !ok {
+			break
+		}
+		length := 16
+		if len(args) > 1 {
+			if n, err := strconv.ParseUint(args[1], 0, 16); err == nil && n > 0 {
+				length = int(n)
+			}
+		}
+		d.printMemory(out, address, length)
+	case "set":
+		if len(args) != 2 {
+			fmt.Fprintln(out, "usage: set <reg|addr> <value>")
+			break
+		}
+		value, err := strconv.ParseUint(args[1], 0, 16)
+		if err != nil {
+			fmt.Fprintf(out, "bad value %s: %v\n", args[1], err)
+			break
+		}
+		if _, err := registerGetter(args[0]); err == nil {
+			setRegister(d.CPU, args[0], value)
+		} else if address, err := d.Resolve(args[0]); err == nil {
+			bus.Poke(d.CPU.Bus, address, uint8(value))
+		} else {
+			fmt.Fprintln(out, err)
+		}
+	case "sym":
+		for _, name := range slices.SortedFunc(maps.Keys(d.Symbols), func(a, b string) int {
+			return int(d.Symbols[a]) - int(d.Symbols[b])
+		}) {
+			fmt.Fprintf(out, "0x%04x %s\n", d.Symbols[name], name)
+		}
+	case "map":
+		decoder, ok := d.CPU.Bus.(*bus.Decoder)
+		if !ok {
+			fmt.Fprintln(out, "the bus has no memory map")
+			break
+		}
+		for _, r := range decoder.Regions() {
+			fmt.Fprintf(out, "0x%04x-0x%04x %s", r.Start, r.End, r.Name)
+			if banked, ok := r.Device.(*bus.Banked); ok {
+				fmt.Fprintf(out, ", bank %d selected", banked.Selected())
+			}
+			fmt.Fprintln(out)
+		}
+	case "reset":
+		d.CPU.Reset()
+		d.printState(out)
+	case "q", "quit":
+		return true
+	case "h", "help":
+		fmt.Fprint(out, helpText)
+	default:
+		fmt.Fprintf(out, "unknown command %s (try help)\n", command)
+	}
+	return false
+}
+
+func (d *Debugger) addressArg(out io.Writer, args []string) (uint16, bool) {
+	if len(args) == 0 {
+		fmt.Fprintln(out, "an address is required")
+		return 0, false
+	}
+	address, err := d.Resolve(args[0])
+	if err != nil {
+		fmt.Fprintln(out, err)
+		return 0, false
+	}
+	return address, true
+}
+
+func (d *Debugger) report(out io.Writer, reason string) {
+	if reason != "" {
+		fmt.Fprintf(out, "stopped: %s\n", reason)
+	}
+	d.printState(out)
+}
+
+func (d *Debugger) printState(out io.Writer) {
+	c := d.CPU
+	fmt.Fprintf(out, "PC: %s  IR: 0x%02x (%s)  Step: 0x%x\n", d.formatAddress(c.ProgramCounter), c.InstructionRegister, OpCode(c.InstructionRegister), c.ClockPulse)
+	fmt.Fprintf(out, "A: 0x%02x  B: 0x%02x  SP: 0x%04x  MAR: 0x%04x  F: %s (0x%02x)  Cycles: %d\n", c.AccumulatorRegister, c.InternalRegister, c.StackPointer, c.MemoryAddressRegister, formatFlags(c.FlagsRegister), uint8(c.FlagsRegister), c.Cycles)
+	if c.ClockPulse == 0 && !c.Halted {
+		fmt.Fprintf(out, "next: %s\n", OpCode(bus.Peek(c.Bus, c.ProgramCounter)))
+	}
+}
+
+func (d *Debugger) printMemory(out io.Writer, start uint16, length int) {
+	for i := range length {
+		address := start + uint16(i)
+		if i%16 == 0 {
+			if i != 0 {
+				fmt.Fprintln(out)
+			}
+			fmt.Fprintf(out, "%04x:", address)
+		}
+		fmt.Fprintf(out, " %02x", bus.Peek(d.CPU.Bus, address))
+	}
+	fmt.Fprintln(out)
+}
+
+func formatFlags(Flags Flag) string {
+	FlagsChars := []rune{}
+	for i := InterruptFlagI; i >= LoBitFlag; i = i >> 1 {
+		Name := i.String()
+		if Flags&i != 0 {
+			FlagsChars = append(FlagsChars, []rune(Name)[len(Name)-1])
+		} else {
+			FlagsChars = append(FlagsChars, '-')
+		}
+	}
+	return string(FlagsChars)
+}
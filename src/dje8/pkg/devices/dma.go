@@ -0,0 +1,129 @@
+package devices
+
+import "damien.live/dje8/pkg/bus"
+
+// DMA controller register offsets within the range it is mapped at
+const (
+	DMASourceLo      = 0x0 // read/write: source address LSB
+	DMASourceHi      = 0x1 // read/write: source address MSB
+	DMADestinationLo = 0x2 // read/write: destination address LSB
+	DMADestinationHi = 0x3 // read/write: destination address MSB
+	DMACountLo       = 0x4 // read/write: bytes left to transfer LSB
+	DMACountHi       = 0x5 // read/write: bytes left to transfer MSB
+	DMAControl       = 0x6 // read/write, see the DMAControl bits
+	DMAStatus        = 0x7 // read: see the DMAStatus bits; write 1s to acknowledge DMADone
+)
+
+// DMAControl bits
+const (
+	DMAStart           uint8 = 1 << iota // begin the transfer; reads back as 0
+	DMAInterruptEnable                   // raise IRQ while DMADone is set
+)
+
+// DMAStatus bits
+const (
+	DMABusy uint8 = 1 << iota // a transfer is in progress (DMA_REQ is asserted)
+	DMADone                   // the last transfer completed
+)
+
+// DMA is a block copy controller for an expansion slot.  Once started it
+// requests the bus and copies count bytes from source to destination,
+// ascending.  Each byte takes two bus clocks, one to read it and one to
+// write it.  The registers count along with the transfer.
+type DMA struct {
+	source      uint16
+	destination uint16
+	count       uint16
+	control     uint8
+	status      uint8
+	latch       uint8 // byte read, waiting to be written
+	latched     bool
+}
+
+func NewDMA() *DMA {
+	return &DMA{}
+}
+
+func (d *DMA) Read8(offset uint16) uint8 {
+	switch offset {
+	case DMASourceLo:
+		return uint8(d.source)
+	case DMASourceHi:
+		return uint8(d.source >> 8)
+	case DMADestinationLo:
+		return uint8(d.destination)
+	case DMADestinationHi:
+		return uint8(d.destination >> 8)
+	case DMACountLo:
+		return uint8(d.count)
+	case DMACountHi:
+		return uint8(d.count >> 8)
+	case DMAControl:
+		return d.control
+	case DMAStatus:
+		return d.status
+	}
+	return 0xff // unused registers float high like the data bus
+}
+
+func (d *DMA) Write8(offset uint16, value uint8) {
+	switch offset {
+	case DMASourceLo:
+		d.source = d.source&0xff00 | uint16(value)
+	case DMASourceHi:
+		d.source = d.source&0x00ff | uint16(value)<<8
+	case DMADestinationLo:
+		d.destination = d.destination&0xff00 | uint16(value)
+	case DMADestinationHi:
+		d.destination = d.destination&0x00ff | uint16(value)<<8
+	case DMACountLo:
+		d.count = d.count&0xff00 | uint16(value)
+	case DMACountHi:
+		d.count = d.count&0x00ff | uint16(value)<<8
+	case DMAControl:
+		d.control = value &^ DMAStart
+		if value&DMAStart != 0 {
+			d.status &^= DMADone
+			d.status |= DMABusy
+			d.latched = false
+			if d.count == 0 {
+				d.finish()
+			}
+		}
+	case DMAStatus:
+		d.status &^= value & DMADone
+	}
+}
+
+// BusRequest asserts DMA_REQ while a transfer is in progress
+func (d *DMA) BusRequest() bool {
+	return d.status&DMABusy != 0
+}
+
+// BusCycle moves half a byte: a read on one clock, the write on the next
+func (d *DMA) BusCycle(b bus.Bus) {
+	if !d.latched {
+		d.latch = b.Read8(d.source)
+		d.latched = true
+		return
+	}
+	b.Write8(d.destination, d.latch)
+	d.latched = false
+	d.source++
+	d.destination++
+	d.count--
+	if d.count == 0 {
+		d.finish()
+	}
+}
+
+func (d *DMA) finish() {
+	d.status &^= DMABusy
+	d.status |= DMADone
+}
+
+// IRQ reports whether the controller is requesting an interrupt, i.e. the
+// interrupt is enabled and a transfer has completed unacknowledged.
+func (d *DMA) IRQ() bool {
+	return d.control&DMAInterruptEnable != 0 && d.status&DMADone != 0
+}
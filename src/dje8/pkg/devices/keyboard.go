@@ -0,0 +1,185 @@
+package devices
+
+import (
+	"io"
+	"strings"
+	"sync"
+)
+
+// Keyboard register offsets within the 16 byte range the keyboard is
+// mapped at
+const (
+	KeyboardData     = 0x0 // read: ASCII code of the oldest key, removing it from the FIFO
+	KeyboardStatus   = 0x1 // read only, see the KeyboardStatus bits
+	KeyboardControl  = 0x2 // read/write, see the KeyboardControl bits
+	KeyboardScanCode = 0x3 // read: scan code of the oldest key, leaving it in the FIFO
+)
+
+// KeyboardStatus bits
+const (
+	KeyboardKeyAvailable uint8 = 1 << iota // at least one key is waiting in the FIFO
+	KeyboardFull                           // the FIFO is full; further keys wait at the host
+)
+
+// KeyboardControl bits
+const (
+	KeyboardInterruptEnable uint8 = 1 << iota // raise IRQ while a key is waiting
+)
+
+// KeyboardFIFOSize is the number of keys the keyboard interface buffers
+const KeyboardFIFOSize = 16
+
+// Key is one entry in the keyboard FIFO
+type Key struct {
+	ASCII    uint8
+	ScanCode uint8 // PC set 1 make code, 0 if the key has none
+}
+
+// Keyboard is a keyboard interface with a small FIFO of key presses, a
+// status register and an optional interrupt.  Keys come from the host, the
+// terminal or a scripted keystroke file, and wait at the host while the
+// FIFO is full rather than being dropped, so a script is always delivered
+// in full.
+type Keyboard struct {
+	mu      sync.Mutex
+	fifo    []Key
+	pending []byte // keys typed at the host, waiting for space in the FIFO
+	control uint8
+}
+
+// NewKeyboard returns a keyboard fed from source, e.g. the terminal, on its
+// own goroutine until source returns an error.  Every byte read is one key
+// press.
+func NewKeyboard(source io.Reader) *Keyboard {
+	k := &Keyboard{}
+	go k.receive(source)
+	return k
+}
+
+// NewScriptedKeyboard returns a keyboard that has already been typed the
+// keystrokes in script, one key per byte
+func NewScriptedKeyboard(script []byte) *Keyboard {
+	k := &Keyboard{}
+	k.Type(script)
+	return k
+}
+
+func (k *Keyboard) receive(source io.Reader) {
+	buf := make([]byte, 64)
+	for {
+		n, err := source.Read(buf)
+		k.Type(buf[:n])
+		if err != nil {
+			return
+		}
+	}
+}
+
+// Type queues a key press for each byte of keys
+func (k *Keyboard) Type(keys []byte) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.pending = append(k.pending, keys...)
+	k.refill()
+}
+
+// refill moves keys from the host into any free space in the FIFO
+func (k *Keyboard) refill() {
+	for len(k.pending) > 0 && len(k.fifo) < KeyboardFIFOSize {
+		k.fifo = append(k.fifo, Key{k.pending[0], ScanCode(k.pending[0])})
+		k.pending = k.pending[1:]
+	}
+}
+
+func (k *Keyboard) Read8(offset uint16) uint8 {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	switch offset {
+	case KeyboardData:
+		if len(k.fifo) == 0 {
+			return 0
+		}
+		key := k.fifo[0]
+		k.fifo = k.fifo[1:]
+		k.refill()
+		return key.ASCII
+	case KeyboardStatus:
+		var status uint8
+		if len(k.fifo) > 0 {
+			status |= KeyboardKeyAvailable
+		}
+		if len(k.fifo) >= KeyboardFIFOSize {
+			status |= KeyboardFull
+		}
+		return status
+	case KeyboardControl:
+		return k.control
+	case KeyboardScanCode:
+		if len(k.fifo) == 0 {
+			return 0
+		}
+		return k.fifo[0].ScanCode
+	}
+	return 0xff // unused registers float high like the data bus
+}
+
+// Peek8 reads a register without removing a key from the FIFO
+func (k *Keyboard) Peek8(offset uint16) uint8 {
+	if offset == KeyboardData {
+		k.mu.Lock()
+		defer k.mu.Unlock()
+		if len(k.fifo) == 0 {
+			return 0
+		}
+		return k.fifo[0].ASCII
+	}
+	return k.Read8(offset)
+}
+
+func (k *Keyboard) Write8(offset uint16, value uint8) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if offset == KeyboardControl {
+		k.control = value
+	}
+}
+
+// IRQ reports whether the keyboard is requesting an interrupt, i.e. the
+// interrupt is enabled and a key is waiting.
+func (k *Keyboard) IRQ() bool {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return k.control&KeyboardInterruptEnable != 0 && len(k.fifo) > 0
+}
+
+// scanCodeRows lists the keys of a US layout by PC set 1 make code: each
+// row's keys have consecutive codes from the row's first code, unshifted
+// then shifted
+var scanCodeRows = []struct {
+	first     uint8
+	unshifted string
+	shifted   string
+}{
+	{0x01, "\x1b1234567890-=\x08\t", "\x1b!@#$%^&*()_+\x08\t"},
+	{0x10, "qwertyuiop[]\r", "QWERTYUIOP{}\r"},
+	{0x1e, "asdfghjkl;'`", "ASDFGHJKL:\"~"},
+	{0x2b, "\\zxcvbnm,./", "|ZXCVBNM<>?"},
+	{0x39, " ", " "},
+}
+
+// ScanCode returns the PC set 1 make code of the key that types ascii on a
+// US layout keyboard, or 0 if there is none.  Line feed is taken as Enter.
+func ScanCode(ascii uint8) uint8 {
+	if ascii == '\n' {
+		ascii = '\r'
+	}
+	for _, row := range scanCodeRows {
+		if i := strings.IndexByte(row.unshifted, ascii); i >= 0 {
+			return row.first + uint8(i)
+		}
+		if i := strings.IndexByte(row.shifted, ascii); i >= 0 {
+			return row.first + uint8(i)
+		}
+	}
+	return 0
+}
@@ -0,0 +1,74 @@
+package devices
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// PTY is the controlling side of a pseudo terminal.  Programs such as
+// screen or minicom connect to the terminal side at Name.
+type PTY struct {
+	*os.File
+	Name  string
+	slave *os.File // held open so reads don't fail while no one is connected
+}
+
+// OpenPTY allocates a new pseudo terminal
+func OpenPTY() (*PTY, string, error) {
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		return nil, "", err
+	}
+	var unlock int32
+	if err := ioctl(master.Fd(), syscall.TIOCSPTLCK, uintptr(unsafe.Pointer(&unlock))); err != nil {
+		master.Close()
+		return nil, "", err
+	}
+	var number uint32
+	if err := ioctl(master.Fd(), syscall.TIOCGPTN, uintptr(unsafe.Pointer(&number))); err != nil {
+		master.Close()
+		return nil, "", err
+	}
+	name := fmt.Sprintf("/dev/pts/%d", number)
+	slave, err := os.OpenFile(name, os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		master.Close()
+		return nil, "", err
+	}
+	if err := rawPTY(slave); err != nil {
+		slave.Close()
+		master.Close()
+		return nil, "", err
+	}
+	return &PTY{master, name, slave}, name, nil
+}
+
+// rawPTY turns off echo, line editing and output processing on the
+// terminal side, so the bytes the UART sends are neither echoed back to it
+// while no one is connected nor rewritten on the way
+func rawPTY(slave *os.File) error {
+	var termios syscall.Termios
+	if err := ioctl(slave.Fd(), syscall.TCGETS, uintptr(unsafe.Pointer(&termios))); err != nil {
+		return err
+	}
+	termios.Iflag &^= syscall.ICRNL | syscall.INLCR | syscall.IGNCR | syscall.IXON | syscall.ISTRIP
+	termios.Oflag &^= syscall.OPOST
+	termios.Lflag &^= syscall.ECHO | syscall.ECHONL | syscall.ICANON | syscall.ISIG | syscall.IEXTEN
+	termios.Cc[syscall.VMIN] = 1
+	termios.Cc[syscall.VTIME] = 0
+	return ioctl(slave.Fd(), syscall.TCSETS, uintptr(unsafe.Pointer(&termios)))
+}
+
+func (p *PTY) Close() error {
+	p.slave.Close()
+	return p.File.Close()
+}
+
+func ioctl(fd uintptr, request uintptr, arg uintptr) error {
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, request, arg); errno != 0 {
+		return errno
+	}
+	return nil
+}
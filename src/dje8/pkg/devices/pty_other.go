@@ -0,0 +1,19 @@
+//go:build !linux
+
+package devices
+
+import (
+	"errors"
+	"os"
+)
+
+// PTY is the controlling side of a pseudo terminal
+type PTY struct {
+	*os.File
+	Name string
+}
+
+// OpenPTY allocates a new pseudo terminal.  It is only implemented on Linux.
+func OpenPTY() (*PTY, string, error) {
+	return nil, "", errors.New("pseudo terminals are only supported on linux")
+}
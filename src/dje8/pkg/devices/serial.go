@@ -0,0 +1,57 @@
+package devices
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+)
+
+// Stdio is a UART backend on the host terminal: received bytes come from
+// standard input and transmitted bytes go to standard output.
+type Stdio struct{}
+
+func (Stdio) Read(p []byte) (int, error)  { return os.Stdin.Read(p) }
+func (Stdio) Write(p []byte) (int, error) { return os.Stdout.Write(p) }
+func (Stdio) Close() error                { return nil }
+
+// ListenTCP waits for a single client to connect to address (e.g.
+// "localhost:6502") and returns the connection as a UART backend.
+func ListenTCP(address string) (net.Conn, error) {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	defer listener.Close()
+	return listener.Accept()
+}
+
+// OpenSerialBackend opens a UART backend from a command line style spec:
+//
+//	stdio          the host terminal
+//	pty            a new pseudo terminal (Unix only)
+//	tcp:ADDRESS    a TCP socket; waits for one client to connect
+//
+// The returned description says where to connect, e.g. the PTY's path.
+func OpenSerialBackend(spec string) (backend io.ReadWriteCloser, description string, err error) {
+	switch {
+	case spec == "stdio":
+		return Stdio{}, "the terminal", nil
+	case spec == "pty":
+		pty, name, err := OpenPTY()
+		if err != nil {
+			return nil, "", err
+		}
+		return pty, name, nil
+	case strings.HasPrefix(spec, "tcp:"):
+		address := strings.TrimPrefix(spec, "tcp:")
+		fmt.Fprintf(os.Stderr, "waiting for a connection on %s\n", address)
+		conn, err := ListenTCP(address)
+		if err != nil {
+			return nil, "", err
+		}
+		return conn, conn.RemoteAddr().String(), nil
+	}
+	return nil, "", fmt.Errorf("unknown serial backend %s, expected stdio, pty or tcp:ADDRESS", spec)
+}
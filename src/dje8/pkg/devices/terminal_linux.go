@@ -0,0 +1,28 @@
+package devices
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// RawTerminal switches the terminal f to deliver every key press as it
+// happens, without echo or line editing.  Ctrl-C still interrupts.  The
+// returned function puts the terminal back the way it was.
+func RawTerminal(f *os.File) (restore func() error, err error) {
+	var saved syscall.Termios
+	if err := ioctl(f.Fd(), syscall.TCGETS, uintptr(unsafe.Pointer(&saved))); err != nil {
+		return nil, err
+	}
+	raw := saved
+	raw.Lflag &^= syscall.ICANON | syscall.ECHO
+	raw.Iflag &^= syscall.ICRNL // Enter reads as a carriage return
+	raw.Cc[syscall.VMIN] = 1
+	raw.Cc[syscall.VTIME] = 0
+	if err := ioctl(f.Fd(), syscall.TCSETS, uintptr(unsafe.Pointer(&raw))); err != nil {
+		return nil, err
+	}
+	return func() error {
+		return ioctl(f.Fd(), syscall.TCSETS, uintptr(unsafe.Pointer(&saved)))
+	}, nil
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+
+package devices
+
+import (
+	"errors"
+	"os"
+)
+
+// RawTerminal switches the terminal f to deliver every key press as it
+// happens.  It is only implemented on Linux.
+func RawTerminal(f *os.File) (restore func() error, err error) {
+	return nil, errors.New("raw terminal input is only supported on linux")
+}
@@ -0,0 +1,83 @@
+package devices
+
+// Timer register offsets within the 16 byte range the timer is mapped at
+const (
+	TimerReloadLo = 0x0 // write: reload value LSB; read: current count LSB
+	TimerReloadHi = 0x1 // write: reload value MSB; read: current count MSB
+	TimerControl  = 0x2 // read/write, see the TimerControl bits
+	TimerStatus   = 0x3 // read: see the TimerStatus bits; write 1s to acknowledge them
+)
+
+// TimerControl bits
+const (
+	TimerEnable          uint8 = 1 << iota // count down once per CPU clock
+	TimerInterruptEnable                   // raise IRQ while TimerExpired is set
+)
+
+// TimerStatus bits
+const (
+	TimerExpired uint8 = 1 << iota // the count reached zero and was reloaded
+)
+
+// Timer is an interval timer clocked by the CPU.  While enabled it counts
+// down from the reload value once per microstep; on reaching zero it sets
+// TimerExpired, which stays set until acknowledged, and starts again from
+// the reload value.  A reload value of 0 counts 65536 clocks.
+type Timer struct {
+	reload  uint16
+	count   uint16
+	control uint8
+	status  uint8
+}
+
+func NewTimer() *Timer {
+	return &Timer{}
+}
+
+func (t *Timer) Read8(offset uint16) uint8 {
+	switch offset {
+	case TimerReloadLo:
+		return uint8(t.count)
+	case TimerReloadHi:
+		return uint8(t.count >> 8)
+	case TimerControl:
+		return t.control
+	case TimerStatus:
+		return t.status
+	}
+	return 0xff // unused registers float high like the data bus
+}
+
+func (t *Timer) Write8(offset uint16, value uint8) {
+	switch offset {
+	case TimerReloadLo:
+		t.reload = t.reload&0xff00 | uint16(value)
+	case TimerReloadHi:
+		t.reload = t.reload&0x00ff | uint16(value)<<8
+	case TimerControl:
+		if value&TimerEnable != 0 && t.control&TimerEnable == 0 {
+			t.count = t.reload // (re)starting the timer loads a fresh count
+		}
+		t.control = value
+	case TimerStatus:
+		t.status &= ^value
+	}
+}
+
+// Tick advances the timer by one CPU clock
+func (t *Timer) Tick() {
+	if t.control&TimerEnable == 0 {
+		return
+	}
+	t.count--
+	if t.count == 0 {
+		t.status |= TimerExpired
+		t.count = t.reload
+	}
+}
+
+// IRQ reports whether the timer is requesting an interrupt, i.e. the
+// interrupt is enabled and the timer has expired unacknowledged.
+func (t *Timer) IRQ() bool {
+	return t.control&TimerInterruptEnable != 0 && t.status&TimerExpired != 0
+}
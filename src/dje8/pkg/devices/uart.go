@@ -0,0 +1,107 @@
+package devices
+
+import (
+	"io"
+	"sync"
+)
+
+// UART register offsets within the 16 byte range a UART is mapped at
+const (
+	UARTData    = 0x0 // read: next received byte; write: byte to transmit
+	UARTStatus  = 0x1 // read only, see the UARTStatus bits
+	UARTControl = 0x2 // read/write, see the UARTControl bits
+)
+
+// UARTStatus bits
+const (
+	UARTRxAvailable uint8 = 1 << iota // at least one received byte is waiting in UARTData
+	UARTTxReady                       // a write to UARTData will be transmitted
+)
+
+// UARTControl bits
+const (
+	UARTRxInterruptEnable uint8 = 1 << iota // raise IRQ while a received byte is waiting
+)
+
+// UART is a minimal serial port: one data register, one status register
+// and one control register.  Bytes arriving from the backend are queued
+// until the program reads them; bytes written by the program go straight
+// out to the backend.
+type UART struct {
+	mu      sync.Mutex
+	rx      []byte
+	control uint8
+	backend io.ReadWriter
+	txErr   error
+}
+
+// NewUART returns a UART attached to backend (a terminal, PTY, socket...).
+// Reading from the backend happens on its own goroutine until it returns
+// an error.
+func NewUART(backend io.ReadWriter) *UART {
+	u := &UART{backend: backend}
+	go u.receive()
+	return u
+}
+
+func (u *UART) receive() {
+	buf := make([]byte, 256)
+	for {
+		n, err := u.backend.Read(buf)
+		if n > 0 {
+			u.mu.Lock()
+			u.rx = append(u.rx, buf[:n]...)
+			u.mu.Unlock()
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (u *UART) Read8(offset uint16) uint8 {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	switch offset {
+	case UARTData:
+		if len(u.rx) == 0 {
+			return 0
+		}
+		value := u.rx[0]
+		u.rx = u.rx[1:]
+		return value
+	case UARTStatus:
+		var status uint8
+		if len(u.rx) > 0 {
+			status |= UARTRxAvailable
+		}
+		if u.txErr == nil {
+			status |= UARTTxReady
+		}
+		return status
+	case UARTControl:
+		return u.control
+	}
+	return 0xff // unused registers float high like the data bus
+}
+
+func (u *UART) Write8(offset uint16, value uint8) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	switch offset {
+	case UARTData:
+		if u.txErr == nil {
+			_, u.txErr = u.backend.Write([]byte{value})
+		}
+	case UARTControl:
+		u.control = value
+	}
+}
+
+// IRQ reports whether the UART is requesting an interrupt, i.e. the receive
+// interrupt is enabled and a byte is waiting.
+func (u *UART) IRQ() bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.control&UARTRxInterruptEnable != 0 && len(u.rx) > 0
+}
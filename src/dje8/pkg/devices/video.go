@@ -0,0 +1,151 @@
+package devices
+
+import (
+	"fmt"
+	"io"
+
+	"damien.live/dje8/pkg/bus"
+)
+
+// Text mode geometry.  Each character cell is two bytes in the character
+// buffer, the character followed by its color attribute, row by row.
+const (
+	VideoColumns = 80
+	VideoRows    = 25
+)
+
+// Video controller register offsets within the range the registers are
+// mapped at
+const (
+	VideoMode         = 0x0 // read/write, see the VideoMode bits
+	VideoCursorColumn = 0x1 // read/write: 0-79
+	VideoCursorRow    = 0x2 // read/write: 0-24
+)
+
+// VideoMode bits
+const (
+	VideoEnable       uint8 = 1 << iota // display the character buffer (otherwise the screen is blank)
+	VideoCursorEnable                   // show the cursor as an inverted cell
+)
+
+// Video is an 80x25 text mode video controller.  Its character buffer and
+// its registers are mapped separately: the Buffer at the video character
+// buffer and Registers() at the video controller registers.  A color
+// attribute is the character's foreground color as RRRGGGBB, shown on a
+// black background.
+type Video struct {
+	Buffer bus.RAM
+	mode   uint8
+	column uint8
+	row    uint8
+}
+
+// NewVideo returns a video controller with a blank 4K character buffer and
+// the display enabled
+func NewVideo() *Video {
+	return &Video{Buffer: bus.NewRAM(0x1000), mode: VideoEnable}
+}
+
+// Registers returns the controller registers as a device to map
+func (v *Video) Registers() bus.Device {
+	return videoRegisters{v}
+}
+
+type videoRegisters struct {
+	v *Video
+}
+
+func (r videoRegisters) Read8(offset uint16) uint8 {
+	switch offset {
+	case VideoMode:
+		return r.v.mode
+	case VideoCursorColumn:
+		return r.v.column
+	case VideoCursorRow:
+		return r.v.row
+	}
+	return 0xff // unused registers float high like the data bus
+}
+
+func (r videoRegisters) Write8(offset uint16, value uint8) {
+	switch offset {
+	case VideoMode:
+		r.v.mode = value
+	case VideoCursorColumn:
+		r.v.column = value
+	case VideoCursorRow:
+		r.v.row = value
+	}
+}
+
+// Cell returns the character and color attribute at column, row
+func (v *Video) Cell(column int, row int) (char uint8, attribute uint8) {
+	offset := (row*VideoColumns + column) * 2
+	return v.Buffer[offset], v.Buffer[offset+1]
+}
+
+// Text returns the screen as plain text, one string per row.  Characters
+// outside printable ASCII show as spaces.
+func (v *Video) Text() []string {
+	rows := make([]string, VideoRows)
+	for row := range VideoRows {
+		line := make([]byte, VideoColumns)
+		for column := range VideoColumns {
+			char, _ := v.Cell(column, row)
+			line[column] = printable(char)
+			if v.mode&VideoEnable == 0 {
+				line[column] = ' '
+			}
+		}
+		rows[row] = string(line)
+	}
+	return rows
+}
+
+// Render writes the screen to w as 25 lines of text colored with ANSI
+// 256-color escape sequences
+func (v *Video) Render(w io.Writer) error {
+	for row := range VideoRows {
+		line := []byte{}
+		var current int = -1
+		for column := range VideoColumns {
+			char, attribute := v.Cell(column, row)
+			color := ANSIColor(attribute)
+			cursor := v.mode&VideoCursorEnable != 0 && int(v.column) == column && int(v.row) == row
+			if v.mode&VideoEnable == 0 {
+				char, color = ' ', 0
+			}
+			if cursor { // in the terminal's own colors, so it shows over black cells
+				line = fmt.Appendf(line, "\033[0;7m%c\033[0m", printable(char))
+				current = -1
+				continue
+			}
+			if color != current {
+				line = fmt.Appendf(line, "\033[38;5;%dm", color)
+				current = color
+			}
+			line = append(line, printable(char))
+		}
+		line = append(line, "\033[0m\n"...)
+		if _, err := w.Write(line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ANSIColor maps an RRRGGGBB color attribute to the nearest color in the
+// 6x6x6 cube of the ANSI 256-color palette
+func ANSIColor(attribute uint8) int {
+	red := (int(attribute>>5)*5 + 3) / 7
+	green := (int(attribute>>2&0x7)*5 + 3) / 7
+	blue := (int(attribute&0x3)*5 + 1) / 3
+	return 16 + 36*red + 6*green + blue
+}
+
+func printable(char uint8) byte {
+	if char < 0x20 || char > 0x7e {
+		return ' '
+	}
+	return char
+}
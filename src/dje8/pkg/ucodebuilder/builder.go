@@ -0,0 +1,178 @@
+package ucodebuilder
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+
+	//lint:ignore ST1001 importing common shared across all dje8 cmds
+	. "damien.live/dje8/pkg/common"
+)
+
+// Steps is a run of microsteps.  An instruction's microcode is written as
+// the runs it is made of, so a run shared by several instructions, like
+// Fetch, is only written once.
+type Steps []Control
+
+// Fetch loads the opcode into the Instruction Register.  Every instruction
+// starts with it, as the CPU always takes steps 0 and 1 to fetch.
+var Fetch = Steps{COW | MIW, RO | II | CU}
+
+// Inputs are what microcode can depend on besides the opcode: the lines
+// of the Control ROM address other than the opcode and the step counter.
+// Those are only the Z, C, N and V flags; I is not in the address.
+type Inputs struct {
+	Flags Flag
+}
+
+// addressFlags are the flags in the Control ROM address
+const addressFlags = ZeroFlagZ | CarryFlagC | NegativeFlagN | OverflowFlagV
+
+// addressInputs returns every value of the inputs the Control ROM address
+// holds, in address order
+func addressInputs() []Inputs {
+	var inputs []Inputs
+	for flags := range Flag(16) {
+		inputs = append(inputs, Inputs{Flags: flags})
+	}
+	return inputs
+}
+
+// address returns the Control ROM address of step 0 of op given in, laid
+// out as ControlROMLookup does: flags, opcode, step
+func address(in Inputs, op OpCode) int {
+	return int(in.Flags&addressFlags)<<12 | int(op)<<4
+}
+
+// Builder collects the microcode of every instruction by mnemonic and
+// compiles it into a Control ROM image
+type Builder struct {
+	instructions [LastOpCode + 1]*Instruction
+	errs         []error
+}
+
+// Instruction is the microcode of one opcode as a function of the inputs
+type Instruction struct {
+	op        OpCode
+	microcode func(in Inputs) []Steps
+	builder   *Builder
+	wraps     bool // no STR, see Wrap
+}
+
+// ALU returns the control signals selecting mode
+func ALU(mode ALUMode) Control {
+	return Control(mode) * AU0
+}
+
+func NewBuilder() *Builder {
+	return &Builder{}
+}
+
+// Define sets the microcode of the instruction named mnemonic to runs, one
+// after the other.  STR is added to the last step, so runs need only list
+// the steps that do something.
+func (b *Builder) Define(mnemonic string, runs ...Steps) *Instruction {
+	return b.Conditional(mnemonic, func(Inputs) []Steps { return runs })
+}
+
+// Conditional sets the microcode of the instruction named mnemonic to the
+// runs microcode returns, which may differ with the inputs.  Build calls
+// microcode for every value of the inputs in the Control ROM address.
+func (b *Builder) Conditional(mnemonic string, microcode func(in Inputs) []Steps) *Instruction {
+	op, found := OpCodeLookup[mnemonic]
+	i := &Instruction{op: op, microcode: microcode, builder: b}
+	switch {
+	case !found:
+		b.errorf("%s is not an instruction", mnemonic)
+	case b.instructions[op] != nil:
+		b.errorf("%s is defined more than once", mnemonic)
+	default:
+		b.instructions[op] = i
+	}
+	return i
+}
+
+// When makes the instruction run runs instead when all of flags in the
+// Control ROM address are set (or all clear if set is false).  Variants
+// added later take priority over earlier ones.
+func (i *Instruction) When(flags Flag, set bool, runs ...Steps) *Instruction {
+	if flags&^addressFlags != 0 || flags == 0 {
+		i.builder.errorf("%s: %s are not flags in the Control ROM address", i.op, flags)
+		return i
+	}
+	want := Flag(0)
+	if set {
+		want = flags
+	}
+	otherwise := i.microcode
+	i.microcode = func(in Inputs) []Steps {
+		if in.Flags&flags == want {
+			return runs
+		}
+		return otherwise(in)
+	}
+	return i
+}
+
+// Wrap leaves STR off the instruction, so it runs all 16 steps and the step
+// counter wraps back round to the fetch
+func (i *Instruction) Wrap() *Instruction {
+	i.wraps = true
+	return i
+}
+
+// join concatenates runs and ends them with STR unless wraps is set
+func join(op OpCode, runs []Steps, wraps bool) (Steps, error) {
+	var steps Steps
+	for _, run := range runs {
+		steps = append(steps, run...)
+	}
+	switch {
+	case len(steps) <= len(Fetch) || !slices.Equal(steps[:len(Fetch)], Fetch):
+		return nil, fmt.Errorf("%s does not start with Fetch followed by a step", op)
+	case len(steps) > 16:
+		return nil, fmt.Errorf("%s has %d steps, more than the 16 the step counter can count", op, len(steps))
+	}
+	if !wraps {
+		steps[len(steps)-1] |= STR
+	}
+	return steps, nil
+}
+
+func (b *Builder) errorf(format string, args ...any) {
+	b.errs = append(b.errs, fmt.Errorf(format, args...))
+}
+
+// Build compiles the instructions into the 64K Control ROM image addressed
+// as ControlROMLookup does.  It fails if any opcode is missing or was
+// defined badly.
+func (b *Builder) Build() ([]Control, error) {
+	errs := b.errs
+	ucode := make([]Control, 65536)
+	for op, i := range b.instructions {
+		if i == nil {
+			errs = append(errs, fmt.Errorf("%s is not defined", OpCode(op)))
+			continue
+		}
+		if err := i.compile(ucode); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return ucode, nil
+}
+
+// compile writes the microcode of i for every value of the inputs into
+// ucode, stopping at the first error
+func (i *Instruction) compile(ucode []Control) error {
+	for _, in := range addressInputs() {
+		steps, err := join(i.op, i.microcode(in), i.wraps)
+		if err != nil {
+			return err
+		}
+		copy(ucode[address(in, i.op):], steps)
+	}
+	return nil
+}
@@ -0,0 +1,266 @@
+package ucodebuilder_test
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"damien.live/dje8/pkg/asm"
+	//lint:ignore ST1001 importing common shared across all dje8 cmds
+	. "damien.live/dje8/pkg/common"
+	"damien.live/dje8/pkg/cpu"
+	"damien.live/dje8/pkg/ucodebuilder"
+)
+
+// The ISA tests run a short program for every instruction on an emulated
+// CPU driven by the microcode from BuildUcode, and check what it did to A,
+// memory, SP, PC and the flags.
+
+// isaCase is one program and the machine state before and after it.  The
+// program is assembled at 0x8000 and has an "end: HALT" appended.
+type isaCase struct {
+	Name   string
+	Source string
+	A      uint8
+	Flags  Flag
+	SP     uint16           // 0 for StackStart
+	Memory map[uint16]uint8 // poked before the program runs
+
+	WantA      uint8
+	WantFlags  Flag
+	WantSP     uint16           // 0 for the SP the program started with
+	WantMemory map[uint16]uint8 // only these addresses are checked
+	HaltAt     string           // label of the HALT the program stops at, "" for end
+}
+
+const (
+	V = OverflowFlagV
+	N = NegativeFlagN
+	C = CarryFlagC
+	Z = ZeroFlagZ
+	I = InterruptFlagI
+)
+
+const origin uint16 = 0x8000
+
+// pointer is the zero page pointer the M mode cases use, pointing at data
+var pointer = map[uint16]uint8{0x10: 0x00, 0x11: 0x90}
+
+const data uint16 = 0x9000
+
+var isaCases = []isaCase{
+	{Name: "NOP", Source: "NOP", A: 0x42, Flags: I | C, WantA: 0x42, WantFlags: I | C},
+	{Name: "HALT", Source: "start: HALT\nLODI 1", HaltAt: "start", WantFlags: I, Flags: I},
+
+	{Name: "LODI", Source: "LODI 0x5a", Flags: I, WantA: 0x5a, WantFlags: I},
+	{Name: "LODA", Source: "LODA 0x9000", Flags: I, Memory: at(data, 0x5a), WantA: 0x5a, WantFlags: I},
+	{Name: "LODZ", Source: "LODZ 0x42", Flags: I, Memory: at(0x42, 0x5a), WantA: 0x5a, WantFlags: I},
+	{Name: "LODM", Source: "LODM 0x10", Flags: I, Memory: with(pointer, data, 0x5a), WantA: 0x5a, WantFlags: I},
+	{Name: "STOA", Source: "STOA 0x9000", A: 0x42, Flags: I, WantA: 0x42, WantFlags: I, WantMemory: at(data, 0x42)},
+	{Name: "STOZ", Source: "STOZ 0x20", A: 0x42, Flags: I, WantA: 0x42, WantFlags: I, WantMemory: at(0x20, 0x42)},
+	{Name: "STOM", Source: "STOM 0x10", A: 0x42, Flags: I, Memory: pointer, WantA: 0x42, WantFlags: I, WantMemory: at(data, 0x42)},
+
+	{Name: "ADDI", Source: "ADDI 0x01", A: 0x7f, Flags: I, WantA: 0x80, WantFlags: I | N | V},
+	{Name: "ADDA", Source: "ADDA 0x9000", A: 0x7f, Flags: I, Memory: at(data, 0x01), WantA: 0x80, WantFlags: I | N | V},
+	{Name: "ADDZ", Source: "ADDZ 0x20", A: 0xff, Flags: I, Memory: at(0x20, 0x01), WantA: 0x00, WantFlags: I | Z | C},
+	{Name: "ADDM", Source: "ADDM 0x10", A: 0x10, Flags: I | N, Memory: with(pointer, data, 0x20), WantA: 0x30, WantFlags: I},
+	{Name: "ADCI", Source: "ADCI 0x20", A: 0x10, Flags: I | C, WantA: 0x31, WantFlags: I},
+	{Name: "ADCA", Source: "ADCA 0x9000", A: 0xff, Flags: I | C, Memory: at(data, 0x00), WantA: 0x00, WantFlags: I | Z | C},
+	{Name: "ADCZ", Source: "ADCZ 0x20", A: 0x01, Flags: I, Memory: at(0x20, 0x01), WantA: 0x02, WantFlags: I},
+	{Name: "ADCM", Source: "ADCM 0x10", A: 0x7f, Flags: I | C, Memory: with(pointer, data, 0x00), WantA: 0x80, WantFlags: I | N | V},
+	{Name: "SUBI", Source: "SUBI 0x20", A: 0x10, Flags: I, WantA: 0xf0, WantFlags: I | N | C},
+	{Name: "SUBA", Source: "SUBA 0x9000", A: 0x80, Flags: I, Memory: at(data, 0x01), WantA: 0x7f, WantFlags: I | V},
+	{Name: "SUBZ", Source: "SUBZ 0x20", A: 0x42, Flags: I, Memory: at(0x20, 0x42), WantA: 0x00, WantFlags: I | Z},
+	{Name: "SUBM", Source: "SUBM 0x10", A: 0x50, Flags: I | C, Memory: with(pointer, data, 0x10), WantA: 0x40, WantFlags: I},
+	{Name: "SBCI", Source: "SBCI 0x01", A: 0x10, Flags: I | C, WantA: 0x0e, WantFlags: I},
+	{Name: "SBCA", Source: "SBCA 0x9000", A: 0x00, Flags: I, Memory: at(data, 0x01), WantA: 0xff, WantFlags: I | N | C},
+	{Name: "SBCZ", Source: "SBCZ 0x20", A: 0x05, Flags: I | C, Memory: at(0x20, 0x04), WantA: 0x00, WantFlags: I | Z},
+	{Name: "SBCM", Source: "SBCM 0x10", A: 0x20, Flags: I, Memory: with(pointer, data, 0x10), WantA: 0x10, WantFlags: I},
+	{Name: "NEG", Source: "NEG", A: 0x01, Flags: I | C, WantA: 0xff, WantFlags: I | C | N},
+	{Name: "CMPI", Source: "CMPI 0x42", A: 0x42, Flags: I, WantA: 0x42, WantFlags: I | Z},
+	{Name: "CMPA", Source: "CMPA 0x9000", A: 0x10, Flags: I, Memory: at(data, 0x20), WantA: 0x10, WantFlags: I | N | C},
+	{Name: "CMPZ", Source: "CMPZ 0x20", A: 0x30, Flags: I | Z, Memory: at(0x20, 0x10), WantA: 0x30, WantFlags: I},
+	{Name: "CMPM", Source: "CMPM 0x10", A: 0x80, Flags: I, Memory: with(pointer, data, 0x01), WantA: 0x80, WantFlags: I | V},
+
+	{Name: "ASL", Source: "ASL", A: 0xc1, Flags: I, WantA: 0x82, WantFlags: I | N | C},
+	{Name: "LSL", Source: "LSL", A: 0x40, Flags: I, WantA: 0x80, WantFlags: I | N | V},
+	{Name: "ASR", Source: "ASR", A: 0x81, Flags: I, WantA: 0xc0, WantFlags: I | N | C},
+	{Name: "LSR", Source: "LSR", A: 0x02, Flags: I | C, WantA: 0x01, WantFlags: I},
+	{Name: "LSR carry out", Source: "LSR", A: 0x81, Flags: I | N, WantA: 0x40, WantFlags: I | C},
+	{Name: "ROL", Source: "ROL", A: 0x80, Flags: I | C, WantA: 0x01, WantFlags: I | C | V},
+	{Name: "ROR", Source: "ROR", A: 0x01, Flags: I | C, WantA: 0x80, WantFlags: I | N | C},
+
+	{Name: "ANDI", Source: "ANDI 0x3c", A: 0xf0, Flags: I | Z, WantA: 0x30, WantFlags: I | Z},
+	{Name: "ANDA", Source: "ANDA 0x9000", A: 0xf0, Flags: I, Memory: at(data, 0x3c), WantA: 0x30, WantFlags: I},
+	{Name: "ANDZ", Source: "ANDZ 0x20", A: 0xf0, Flags: I, Memory: at(0x20, 0x3c), WantA: 0x30, WantFlags: I},
+	{Name: "ANDM", Source: "ANDM 0x10", A: 0xf0, Flags: I, Memory: with(pointer, data, 0x3c), WantA: 0x30, WantFlags: I},
+	{Name: "ORI", Source: "ORI 0x0f", A: 0xf0, Flags: I, WantA: 0xff, WantFlags: I},
+	{Name: "ORA", Source: "ORA 0x9000", A: 0xf0, Flags: I, Memory: at(data, 0x0f), WantA: 0xff, WantFlags: I},
+	{Name: "ORZ", Source: "ORZ 0x20", A: 0xf0, Flags: I, Memory: at(0x20, 0x0f), WantA: 0xff, WantFlags: I},
+	{Name: "ORM", Source: "ORM 0x10", A: 0xf0, Flags: I, Memory: with(pointer, data, 0x0f), WantA: 0xff, WantFlags: I},
+	{Name: "XORI", Source: "XORI 0x0f", A: 0xff, Flags: I, WantA: 0xf0, WantFlags: I},
+	{Name: "XORA", Source: "XORA 0x9000", A: 0xff, Flags: I, Memory: at(data, 0x0f), WantA: 0xf0, WantFlags: I},
+	{Name: "XORZ", Source: "XORZ 0x20", A: 0xff, Flags: I, Memory: at(0x20, 0x0f), WantA: 0xf0, WantFlags: I},
+	{Name: "XORM", Source: "XORM 0x10", A: 0xff, Flags: I, Memory: with(pointer, data, 0x0f), WantA: 0xf0, WantFlags: I},
+	{Name: "NOT", Source: "NOT", A: 0x0f, Flags: I | Z, WantA: 0xf0, WantFlags: I | Z},
+
+	{Name: "JMP", Source: "JMP target\nLODI 1\nHALT\ntarget: LODI 2", Flags: I, WantA: 2, WantFlags: I},
+	{Name: "JMPZ", Source: "JMPZ 0x40", Flags: I, Memory: at(0x40, byte(LODI), 2, byte(JMP), 0x02, 0x80), WantA: 2, WantFlags: I},
+	{Name: "JSR", Source: "JSR sub\nADDI 1\ndone: HALT\nsub: LODI 0x10\nRTS", Flags: I, HaltAt: "done",
+		WantA: 0x11, WantFlags: I, WantMemory: at(0xaffe, 0x03, 0x80)},
+	{Name: "JSRZ", Source: "JSRZ 0x40\nADDI 1", Flags: I, Memory: at(0x40, byte(LODI), 0x10, byte(RTS)),
+		WantA: 0x11, WantFlags: I, WantMemory: at(0xaffe, 0x02, 0x80)},
+	{Name: "RTS", Source: "RTS\nLODI 1\nback: LODI 2", Flags: I, SP: 0xaffe, Memory: at(0xaffe, 0x03, 0x80),
+		WantA: 2, WantFlags: I, WantSP: StackStart},
+	{Name: "PUSH", Source: "PUSH", A: 0x42, Flags: I, WantA: 0x42, WantFlags: I, WantSP: 0xafff, WantMemory: at(0xafff, 0x42)},
+	{Name: "POP", Source: "POP", Flags: I, SP: 0xafff, Memory: at(0xafff, 0x42), WantA: 0x42, WantFlags: I, WantSP: StackStart},
+	{Name: "PUSH POP", Source: "LODI 1\nPUSH\nLODI 2\nPUSH\nPOP\nSTOA 0x9000\nPOP", Flags: I,
+		WantA: 1, WantFlags: I, WantMemory: at(data, 2)},
+
+	{Name: "INT RTI", Source: "INT\ndone: HALT\nhandler: LODI 7\nCLC\nRTI", Flags: I | C, Memory: at(InterruptVectorStart, 0x02, 0x80),
+		HaltAt: "done", WantA: 7, WantFlags: I | C, WantMemory: at(0xaffd, byte(I|C), 0x01, 0x80)},
+	{Name: "SEI", Source: "SEI", WantFlags: I},
+	{Name: "CLI", Source: "CLI", Flags: I | Z, WantFlags: Z},
+	{Name: "CLZ", Source: "CLZ", Flags: I | Z | C | N | V, WantFlags: I | C | N | V},
+	{Name: "CLC", Source: "CLC", Flags: I | Z | C | N | V, WantFlags: I | Z | N | V},
+	{Name: "CLN", Source: "CLN", Flags: I | Z | C | N | V, WantFlags: I | Z | C | V},
+	{Name: "CLV", Source: "CLV", Flags: I | Z | C | N | V, WantFlags: I | Z | C | N},
+}
+
+// init adds a taken and a not taken case for every branch, a backward
+// branch, and a case for every reserved opcode, which acts as a NOP
+func init() {
+	for _, branch := range []struct {
+		op    OpCode
+		taken Flag
+	}{{BEQ, Z}, {BNE, 0}, {BCS, C}, {BCC, 0}, {BMI, N}, {BPL, 0}, {BVS, V}, {BVC, 0}} {
+		notTaken := (Z | C | N | V) &^ branch.taken
+		source := branch.op.String() + " +\nLODI 1\n+:"
+		isaCases = append(isaCases,
+			isaCase{Name: branch.op.String() + " taken", Source: source, Flags: I | branch.taken, WantFlags: I | branch.taken},
+			isaCase{Name: branch.op.String() + " not taken", Source: source, Flags: I | notTaken, WantA: 1, WantFlags: I | notTaken})
+	}
+	isaCases = append(isaCases, isaCase{Name: "BNE backward", Source: "LODI 3\n-: SUBI 1\nBNE -", Flags: I, WantFlags: I | Z})
+	for _, op := range []OpCode{RSV1, RSV2, RSV3, RSV4, RSV5, RSV6, RSV7, RSV8} {
+		isaCases = append(isaCases, isaCase{Name: op.String(), Source: op.String(), A: 0x42, Flags: I | V, WantA: 0x42, WantFlags: I | V})
+	}
+}
+
+func at(address uint16, values ...uint8) map[uint16]uint8 {
+	m := make(map[uint16]uint8)
+	for i, value := range values {
+		m[address+uint16(i)] = value
+	}
+	return m
+}
+
+func with(m map[uint16]uint8, address uint16, values ...uint8) map[uint16]uint8 {
+	merged := at(address, values...)
+	for a, value := range m {
+		merged[a] = value
+	}
+	return merged
+}
+
+func TestISA(t *testing.T) {
+	Ucode := ucodebuilder.BuildUcode()
+	covered := make(map[OpCode]bool)
+	for _, c := range isaCases {
+		t.Run(c.Name, func(t *testing.T) {
+			for _, problem := range run(Ucode, c, covered) {
+				t.Error(problem)
+			}
+		})
+	}
+	for op := FirstOpCode; op <= LastOpCode; op++ {
+		if !covered[op] {
+			t.Errorf("%s is not run by any case", op)
+		}
+	}
+}
+
+// TestNOPCycles checks NOP waits the full 16 steps, without STR
+func TestNOPCycles(t *testing.T) {
+	if fewest, most := ucodebuilder.Cycles(ucodebuilder.BuildUcode(), NOP); fewest != 16 || most != 16 {
+		t.Errorf("NOP takes %d to %d clocks, want 16", fewest, most)
+	}
+}
+
+// run runs c and returns what it found wrong, recording the opcodes
+// executed in covered
+func run(Ucode []Control, c isaCase, covered map[OpCode]bool) []string {
+	program, diagnostics := asm.Assemble(strings.NewReader(fmt.Sprintf("#org 0x%04x\n%s\nend: HALT\n", origin, c.Source)), asm.Options{Filename: c.Name})
+	if len(diagnostics) > 0 {
+		return []string{diagnostics[0].Error()}
+	}
+	haltAt := program.Symbols["end"]
+	if c.HaltAt != "" {
+		haltAt = program.Symbols[c.HaltAt]
+	}
+
+	CPU := cpu.New(Ucode, nil)
+	for address, value := range c.Memory {
+		CPU.Bus.Write8(address, value)
+	}
+	for i, value := range program.Bytes {
+		CPU.Bus.Write8(program.Origin+uint16(i), value)
+	}
+	CPU.ProgramCounter = origin
+	CPU.AccumulatorRegister = c.A
+	CPU.FlagsRegister = c.Flags
+	if c.SP != 0 {
+		CPU.StackPointer = c.SP
+	}
+	wantSP := CPU.StackPointer
+	if c.WantSP != 0 {
+		wantSP = c.WantSP
+	}
+	CPU.BeforeStep = func(CPU *cpu.CPU) {
+		if CPU.ClockPulse == 2 {
+			covered[OpCode(CPU.InstructionRegister)] = true
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := CPU.Run(ctx); err != nil {
+		return []string{fmt.Sprintf("did not halt, PC = 0x%04x", CPU.ProgramCounter)}
+	}
+
+	var problems []string
+	check := func(what string, got, want uint64, width int) {
+		if got != want {
+			problems = append(problems, fmt.Sprintf("%s = 0x%0*x, want 0x%0*x", what, width, got, width, want))
+		}
+	}
+	check("A", uint64(CPU.AccumulatorRegister), uint64(c.WantA), 2)
+	if CPU.FlagsRegister != c.WantFlags {
+		problems = append(problems, fmt.Sprintf("flags = %s, want %s", flags(CPU.FlagsRegister), flags(c.WantFlags)))
+	}
+	check("SP", uint64(CPU.StackPointer), uint64(wantSP), 4)
+	check("PC", uint64(CPU.ProgramCounter), uint64(haltAt+1), 4)
+	for address, want := range c.WantMemory {
+		check("memory[0x"+strconv.FormatUint(uint64(address), 16)+"]", uint64(CPU.Bus.Read8(address)), uint64(want), 2)
+	}
+	return problems
+}
+
+// flags formats f as the letters of the flags set, e.g. IZC
+func flags(f Flag) string {
+	var b strings.Builder
+	for _, flag := range []struct {
+		flag   Flag
+		letter byte
+	}{{I, 'I'}, {Z, 'Z'}, {C, 'C'}, {N, 'N'}, {V, 'V'}} {
+		if f&flag.flag != 0 {
+			b.WriteByte(flag.letter)
+		}
+	}
+	if b.Len() == 0 {
+		return "none"
+	}
+	return b.String()
+}
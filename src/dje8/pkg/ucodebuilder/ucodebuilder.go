@@ -5,130 +5,118 @@ import (
 	. "damien.live/dje8/pkg/common"
 )
 
-func BuildUcode() []Control {
-
-	ControlROM := [80][16]Control{
-		/* NOP */ {COW | MIW, RO | II | CU, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
-		/* STOA */ {COW | MIW, RO | II | CU, COW | MIW, ROW | MIW | CUW, RI | AO | STR, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
-		/* STOZ */ {COW | MIW, RO | II | CU, STR, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
-		/* STOM */ {COW | MIW, RO | II | CU, STR, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
-		/* LODI */ {COW | MIW, RO | II | CU, COW | MIW, RO | AI | CU | STR, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
-		/* LODA */ {COW | MIW, RO | II | CU, COW | MIW, ROW | MIW | CUW, RO | AI | STR, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
-		/* LODZ */ {COW | MIW, RO | II | CU, STR, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
-		/* LODM */ {COW | MIW, RO | II | CU, STR, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
-
-		/* NEG */ {COW | MIW, RO | II | CU, STR, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
-		/* ASL */ {COW | MIW, RO | II | CU, STR, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
-		/* ASR */ {COW | MIW, RO | II | CU, STR, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
-		/* NOT */ {COW | MIW, RO | II | CU, STR, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
-		/* LSL */ {COW | MIW, RO | II | CU, STR, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
-		/* LSR */ {COW | MIW, RO | II | CU, STR, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
-		/* ROL */ {COW | MIW, RO | II | CU, STR, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
-		/* ROR */ {COW | MIW, RO | II | CU, STR, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
-
-		/* ADDI */ {COW | MIW, RO | II | CU, STR, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
-		/* ADDA */ {COW | MIW, RO | II | CU, COW | MIW, ROW | MIW | CUW, RO | BI, AU3 | AI | FL | STR, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
-		/* ADDZ */ {COW | MIW, RO | II | CU, STR, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
-		/* ADDM */ {COW | MIW, RO | II | CU, STR, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
-		/* SUBI */ {COW | MIW, RO | II | CU, STR, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
-		/* SUBA */ {COW | MIW, RO | II | CU, COW | MIW, ROW | MIW | CUW, RO | BI, AU3 | AU0 | AI | FL | STR, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
-		/* SUBZ */ {COW | MIW, RO | II | CU, STR, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
-		/* SUBM */ {COW | MIW, RO | II | CU, STR, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
-
-		/* ADCI */ {COW | MIW, RO | II | CU, STR, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
-		/* ADCA */ {COW | MIW, RO | II | CU, STR, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
-		/* ADCZ */ {COW | MIW, RO | II | CU, STR, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
-		/* ADCM */ {COW | MIW, RO | II | CU, STR, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
-		/* SBCI */ {COW | MIW, RO | II | CU, STR, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
-		/* SBCA */ {COW | MIW, RO | II | CU, STR, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
-		/* SBCZ */ {COW | MIW, RO | II | CU, STR, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
-		/* SBCM */ {COW | MIW, RO | II | CU, STR, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
-
-		/* ANDI */ {COW | MIW, RO | II | CU, STR, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
-		/* ANDA */ {COW | MIW, RO | II | CU, STR, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
-		/* ANDZ */ {COW | MIW, RO | II | CU, STR, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
-		/* ANDM */ {COW | MIW, RO | II | CU, STR, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
-		/* ORI */ {COW | MIW, RO | II | CU, STR, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
-		/* ORA */ {COW | MIW, RO | II | CU, STR, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
-		/* ORZ */ {COW | MIW, RO | II | CU, STR, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
-		/* ORM */ {COW | MIW, RO | II | CU, STR, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
-
-		/* XORI */ {COW | MIW, RO | II | CU, STR, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
-		/* XORA */ {COW | MIW, RO | II | CU, STR, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
-		/* XORZ */ {COW | MIW, RO | II | CU, STR, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
-		/* XORM */ {COW | MIW, RO | II | CU, STR, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
-		/* CMPI */ {COW | MIW, RO | II | CU, STR, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
-		/* CMPA */ {COW | MIW, RO | II | CU, STR, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
-		/* CMPZ */ {COW | MIW, RO | II | CU, STR, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
-		/* CMPM */ {COW | MIW, RO | II | CU, STR, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
+// Runs of steps shared by the addressing modes.  Each leaves the Memory
+// Address Register holding the address of the operand.
+var (
+	immediate = Steps{COW | MIW}
+	absolute  = Steps{COW | MIW, ROW | MIW | CUW}
+	// zeroPage saves PC below the stack, loads it with 0x00 (A AND NOT A)
+	// and the operand and uses it as the address.  restore must follow.
+	zeroPage = Steps{PDW | ALU(ALUNOT) | BI, POW | MIW, COW | RIW, COW | MIW | ALU(ALUAND) | CIH, RO | CIL, COW | MIW}
+	indirect = Steps{ROW | MIW} // after zeroPage: the ZeroPage address holds the address
+	restore  = Steps{POW | MIW, ROW | CIW | PUW, CU}
+)
 
-		/* BEQ */ {COW | MIW, RO | II | CU, CUW | STR, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}, // TODO currently absolute, make 8-bit relative
-		/* BNE */ {COW | MIW, RO | II | CU, STR, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
-		/* BCS */ {COW | MIW, RO | II | CU, CUW | STR, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}, // TODO currently absolute, make 8-bit relative
-		/* BCC */ {COW | MIW, RO | II | CU, STR, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
-		/* BMI */ {COW | MIW, RO | II | CU, STR, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
-		/* BPL */ {COW | MIW, RO | II | CU, STR, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
-		/* BVS */ {COW | MIW, RO | II | CU, STR, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
-		/* BVC */ {COW | MIW, RO | II | CU, STR, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
+// branch adds the signed offset to the Program Counter, which points at it
+var branch = Steps{COW | MIW, RO | CR}
+
+// Microcode defines every instruction in a Builder
+func Microcode() *Builder {
+	b := NewBuilder()
+
+	b.Define("NOP", Fetch, Steps{0}).Wrap() // waits as long as an instruction can take
+	b.Define("STOA", Fetch, absolute, Steps{AO | RI})
+	b.Define("STOZ", Fetch, zeroPage, Steps{AO | RI}, restore)
+	b.Define("STOM", Fetch, zeroPage, indirect, Steps{AO | RI}, restore)
+	b.Define("LODI", Fetch, immediate, Steps{RO | AI | CU})
+	b.Define("LODA", Fetch, absolute, Steps{RO | AI})
+	b.Define("LODZ", Fetch, zeroPage, Steps{RO | AI}, restore)
+	b.Define("LODM", Fetch, zeroPage, indirect, Steps{RO | AI}, restore)
+
+	b.Define("NEG", Fetch, Steps{ALU(ALUNEG) | AI | FL})
+	b.Define("ASL", Fetch, Steps{AO | BI, ALU(ALUADD) | AI | FL})
+	b.Define("ASR", Fetch, Steps{ALU(ALUASR) | AI | FL})
+	b.Define("NOT", Fetch, Steps{ALU(ALUNOT) | AI})
+	b.Define("LSL", Fetch, Steps{AO | BI, ALU(ALUADD) | AI | FL})
+	b.Define("LSR", Fetch, Steps{FM, ALU(ALUROR) | AI | FL}) // clear C, then rotate it in
+	b.Define("ROL", Fetch, Steps{AO | BI, ALU(ALUADC) | AI | FL})
+	b.Define("ROR", Fetch, Steps{ALU(ALUROR) | AI | FL})
+
+	for _, op := range []struct {
+		name string
+		mode ALUMode
+		use  Control // what the result goes to
+	}{
+		{"ADD", ALUADD, AI | FL}, {"SUB", ALUSUB, AI | FL}, {"ADC", ALUADC, AI | FL}, {"SBC", ALUSBC, AI | FL},
+		{"AND", ALUAND, AI}, {"OR", ALUOR, AI}, {"XOR", ALUXOR, AI}, {"CMP", ALUCMP, FL},
+	} {
+		b.Define(op.name+"I", Fetch, immediate, Steps{RO | BI | CU, ALU(op.mode) | op.use})
+		b.Define(op.name+"A", Fetch, absolute, Steps{RO | BI, ALU(op.mode) | op.use})
+		b.Define(op.name+"Z", Fetch, zeroPage, Steps{RO | BI, ALU(op.mode) | op.use}, restore)
+		b.Define(op.name+"M", Fetch, zeroPage, indirect, Steps{RO | BI, ALU(op.mode) | op.use}, restore)
+	}
 
-		/* SEI */ {COW | MIW, RO | II | CU, STR, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
-		/* JMP */ {COW | MIW, RO | II | CU, COW | MIW, ROW | CIW | STR, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
-		/* JMPZ */ {COW | MIW, RO | II | CU, STR, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
-		/* JSR */ {COW | MIW, RO | II | CU, STR, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
-		/* JSRZ */ {COW | MIW, RO | II | CU, STR, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
-		/* RTS */ {COW | MIW, RO | II | CU, STR, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
-		/* INT */ {COW | MIW, RO | II | CU, STR, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
-		/* RTI */ {COW | MIW, RO | II | CU, STR, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
+	// a branch not taken steps over its offset
+	b.Define("BEQ", Fetch, Steps{CU}).When(ZeroFlagZ, true, Fetch, branch)
+	b.Define("BNE", Fetch, Steps{CU}).When(ZeroFlagZ, false, Fetch, branch)
+	b.Define("BCS", Fetch, Steps{CU}).When(CarryFlagC, true, Fetch, branch)
+	b.Define("BCC", Fetch, Steps{CU}).When(CarryFlagC, false, Fetch, branch)
+	b.Define("BMI", Fetch, Steps{CU}).When(NegativeFlagN, true, Fetch, branch)
+	b.Define("BPL", Fetch, Steps{CU}).When(NegativeFlagN, false, Fetch, branch)
+	b.Define("BVS", Fetch, Steps{CU}).When(OverflowFlagV, true, Fetch, branch)
+	b.Define("BVC", Fetch, Steps{CU}).When(OverflowFlagV, false, Fetch, branch)
+
+	b.Define("SEI", Fetch, Steps{FM})
+	b.Define("JMP", Fetch, Steps{COW | MIW, ROW | CIW})
+	b.Define("JMPZ", Fetch, Steps{ALU(ALUNOT) | BI, COW | MIW | ALU(ALUAND) | CIH, RO | CIL}) // PC = 0x00 (A AND NOT A) and the operand
+	// push the return address, back up to the operand with the idle data bus as -1, jump
+	b.Define("JSR", Fetch, Steps{PDW | CUW, POW | MIW, COW | RIW | CR, CR, COW | MIW, ROW | CIW})
+	b.Define("JSRZ", Fetch, Steps{PDW | CU | ALU(ALUNOT) | BI, POW | MIW, COW | RIW | CR, COW | MIW | ALU(ALUAND) | CIH, RO | CIL})
+	b.Define("RTS", Fetch, Steps{POW | MIW, ROW | CIW | PUW})
+	// push PC and flags, disable interrupts, jump through the vector
+	b.Define("INT", Fetch, Steps{PDW, POW | MIW, COW | RIW | PD, POW | MIW, FO | RI | FM, MIW, ROW | CIW})
+	b.Define("RTI", Fetch, Steps{POW | MIW, RO | FL | PU, POW | MIW, ROW | CIW | PUW}) // pop flags and PC
+
+	b.Define("CLZ", Fetch, Steps{FM})
+	b.Define("CLC", Fetch, Steps{FM})
+	b.Define("CLN", Fetch, Steps{FM})
+	b.Define("CLV", Fetch, Steps{FM})
+	b.Define("CLI", Fetch, Steps{FM})
+	for _, reserved := range []string{"RSV1", "RSV2", "RSV3", "RSV4", "RSV5", "RSV6", "RSV7", "RSV8"} {
+		b.Define(reserved, Fetch, Steps{0})
+	}
+	b.Define("PUSH", Fetch, Steps{PD, POW | MIW, AO | RI})
+	b.Define("POP", Fetch, Steps{POW | MIW, RO | AI | PU})
+	b.Define("HALT", Fetch, Steps{HLT})
 
-		/* CLZ */ {COW | MIW, RO | II | CU, STR, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
-		/* RSV1 */ {COW | MIW, RO | II | CU, STR, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
-		/* CLC */ {COW | MIW, RO | II | CU, STR, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
-		/* RSV2 */ {COW | MIW, RO | II | CU, STR, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
-		/* CLN */ {COW | MIW, RO | II | CU, STR, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
-		/* RSV3 */ {COW | MIW, RO | II | CU, STR, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
-		/* CLV */ {COW | MIW, RO | II | CU, STR, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
-		/* RSV4 */ {COW | MIW, RO | II | CU, STR, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
+	return b
+}
 
-		/* CLI */ {COW | MIW, RO | II | CU, STR, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
-		/* RSV5 */ {COW | MIW, RO | II | CU, STR, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
-		/* RSV6 */ {COW | MIW, RO | II | CU, STR, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
-		/* RSV7 */ {COW | MIW, RO | II | CU, STR, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
-		/* RSV8 */ {COW | MIW, RO | II | CU, STR, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
-		/* PUSH */ {COW | MIW, RO | II | CU, STR, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
-		/* POP */ {COW | MIW, RO | II | CU, STR, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
-		/* HALT */ {COW | MIW, RO | II | CU, HLT | STR, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
+// BuildUcode returns the Control ROM image of the microcode defined by
+// Microcode
+func BuildUcode() []Control {
+	ucode, err := Microcode().Build()
+	if err != nil {
+		panic(err)
 	}
+	return ucode
+}
 
-	var Ucode [65536]Control
-
-	for i := range 16 {
-		for j, Instr := range ControlROM {
-			if i&int(CarryFlagC) != 0 && j == int(BCS) {
-				Instr = ControlROM[JMP]
-			}
-			if i&int(ZeroFlagZ) != 0 && j == int(BEQ) {
-				Instr = ControlROM[JMP]
+// Cycles returns the fewest and most clocks op takes in ucode over every
+// value of the inputs in the Control ROM address: each microstep up to the
+// one that resets the step counter or halts, or all 16 if none does
+func Cycles(ucode []Control, op OpCode) (fewest, most int) {
+	fewest = 16
+	for _, in := range addressInputs() {
+		base := address(in, op)
+		steps := 16
+		for step := 2; step < 16; step++ {
+			if ucode[base+step]&(STR|HLT) != 0 {
+				steps = step + 1
+				break
 			}
-			idx := i<<12 + j*16
-			copy(Ucode[idx:], Instr[:])
 		}
+		fewest, most = min(fewest, steps), max(most, steps)
 	}
-
-	// var idx uint32 = 0
-	// for i := range 256 {
-	// 	for j, Instr := range ControlROM {
-	// 		if j == 7 && i/16&int(CarryFlagC) != 0 {
-	// 			Instr = ControlROM[6]
-	// 		}
-	// 		if j == 8 && i/16&int(ZeroFlagZ) != 0 {
-	// 			Instr = ControlROM[6]
-	// 		}
-	// 		for _, Word := range Instr {
-	// 			Ucode[idx] = Word
-	// 			idx++
-	// 		}
-	// 	}
-	// }
-
-	return Ucode[:]
+	return fewest, most
 }
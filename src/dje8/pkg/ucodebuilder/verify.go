@@ -0,0 +1,197 @@
+package ucodebuilder
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	//lint:ignore ST1001 importing common shared across all dje8 cmds
+	. "damien.live/dje8/pkg/common"
+)
+
+// Problem is something wrong with a microstep of a Control ROM image
+type Problem struct {
+	OpCode  OpCode
+	Flags   []Flag // the values of the flags in the Control ROM address it occurs for
+	Step    int
+	Message string
+}
+
+func (p Problem) Error() string {
+	return fmt.Sprintf("%s step %d (%s): %s", p.OpCode, p.Step, flagValues(p.Flags), p.Message)
+}
+
+// flagValues describes a set of values of the flags in the Control ROM
+// address, e.g. "Z, ZC"
+func flagValues(values []Flag) string {
+	if len(values) == len(addressInputs()) {
+		return "any flags"
+	}
+	var names []string
+	for _, flags := range values {
+		var b strings.Builder
+		for _, flag := range []struct {
+			flag   Flag
+			letter byte
+		}{{ZeroFlagZ, 'Z'}, {CarryFlagC, 'C'}, {NegativeFlagN, 'N'}, {OverflowFlagV, 'V'}} {
+			if flags&flag.flag != 0 {
+				b.WriteByte(flag.letter)
+			}
+		}
+		if b.Len() == 0 {
+			b.WriteString("no flags")
+		}
+		names = append(names, b.String())
+	}
+	return strings.Join(names, ", ")
+}
+
+// IdleBusReads are the steps that read a bus nothing drives on purpose,
+// for the value it is pulled up to: 0xff (-1) on the data bus and the
+// interrupt vector on the address bus
+var IdleBusReads = map[OpCode][]int{
+	JSR:  {4, 5}, // CR with -1 backs PC up to the operand
+	JSRZ: {4},
+	INT:  {7}, // MIW loads the interrupt vector
+}
+
+// Wrapping are the instructions that run all 16 steps without STR on purpose
+var Wrapping = []OpCode{NOP}
+
+var (
+	dataBusDrivers    = []Control{AO, RO, FO}
+	dataBusReaders    = []Control{AI, BI, II, CIL, CIH, RI, CR}
+	addressBusDrivers = []Control{COW, POW, ROW}
+	addressBusReaders = []Control{MIW, CIW, RIW}
+	// the signals loading and incrementing each register.  A step may only
+	// use one increment, and not with a load, as the CPU increments after
+	// loading but the hardware does both on the same edge.
+	registers = []struct {
+		name       string
+		loads      []Control
+		increments []Control
+	}{
+		{"PC", []Control{CIW, CIL, CIH}, []Control{CU, CUW, CR}},
+		{"MAR", []Control{MIW}, []Control{MU, MUW}},
+		{"SP", nil, []Control{PU, PUW, PD, PDW}},
+	}
+)
+
+// reservedALUModes have no ALU function behind them.  There are no reserved
+// control signals to check for: EX0 to EX3 now carry CR, FM, RIW and FO,
+// as the Control Logic Signals table in SPEC.md records.
+var reservedALUModes = []ALUMode{ALUX2_}
+
+// Verify checks every instruction in the Control ROM image ucode, for
+// every value of the flags, for
+//   - more than one driver on the data bus or the address bus
+//   - a read of a bus nothing drives, except those in IdleBusReads
+//   - RI or RIW before the Memory Address Register is loaded, when it
+//     still holds the address of the opcode
+//   - more than one increment of the same register, or a load and an
+//     increment of it
+//   - reserved ALU modes
+//   - no STR or HLT within 16 steps, except for Wrapping instructions
+//
+// The microsteps after STR or HLT are never run and are not checked.
+func Verify(ucode []Control) []Problem {
+	var problems []Problem
+	type key struct {
+		op      OpCode
+		step    int
+		message string
+	}
+	found := make(map[key]int) // index in problems, so each is reported once with all its flags
+	report := func(in Inputs, op OpCode, step int, format string, args ...any) {
+		k := key{op, step, fmt.Sprintf(format, args...)}
+		if i, seen := found[k]; seen {
+			problems[i].Flags = append(problems[i].Flags, in.Flags)
+			return
+		}
+		found[k] = len(problems)
+		problems = append(problems, Problem{op, []Flag{in.Flags}, step, k.message})
+	}
+
+	for op := FirstOpCode; op <= LastOpCode; op++ {
+		for _, in := range addressInputs() {
+			base := address(in, op)
+			marLoaded := false
+			ended := false
+			for step := range 16 {
+				word := ucode[base+step]
+				verifyStep(word, step, marLoaded, slices.Contains(IdleBusReads[op], step), func(format string, args ...any) {
+					report(in, op, step, format, args...)
+				})
+				if word&MIW != 0 && step > 1 {
+					marLoaded = true
+				}
+				if word&(STR|HLT) != 0 {
+					ended = true
+					break
+				}
+			}
+			if !ended && !slices.Contains(Wrapping, op) {
+				report(in, op, 15, "no STR or HLT within 16 steps")
+			}
+		}
+	}
+	return problems
+}
+
+// verifyStep checks one control word, reporting problems with problemf
+func verifyStep(word Control, step int, marLoaded bool, idleBusRead bool, problemf func(format string, args ...any)) {
+	mode := ALUMode((word / AU0) & 0xf)
+	dataDrivers := signals(word, dataBusDrivers)
+	if mode != ALUNOP && mode != ALUCMP {
+		dataDrivers = append(dataDrivers, mode.String())
+	}
+	dataReaders := signals(word, dataBusReaders)
+	if word&FL != 0 && mode == ALUNOP {
+		dataReaders = append(dataReaders, FL.String())
+	}
+	addressDrivers := signals(word, addressBusDrivers)
+	addressReaders := signals(word, addressBusReaders)
+
+	if len(dataDrivers) > 1 {
+		problemf("data bus driven by %s", strings.Join(dataDrivers, " and "))
+	}
+	if len(addressDrivers) > 1 {
+		problemf("address bus driven by %s", strings.Join(addressDrivers, " and "))
+	}
+	if step > 1 && !idleBusRead {
+		if len(dataDrivers) == 0 && len(dataReaders) > 0 {
+			problemf("nothing drives the data bus read by %s", strings.Join(dataReaders, " and "))
+		}
+		if len(addressDrivers) == 0 && len(addressReaders) > 0 {
+			problemf("nothing drives the address bus read by %s", strings.Join(addressReaders, " and "))
+		}
+	}
+	if step > 1 && !marLoaded && word&(RI|RIW) != 0 {
+		problemf("memory written by %s before MIW has loaded the Memory Address Register", strings.Join(signals(word, []Control{RI, RIW}), " and "))
+	}
+	for _, register := range registers {
+		increments := signals(word, register.increments)
+		if len(increments) > 1 {
+			problemf("conflicting increments %s", strings.Join(increments, " and "))
+		}
+		if loads := signals(word, register.loads); len(loads) > 0 && len(increments) > 0 {
+			problemf("%s loaded by %s and incremented by %s on the same clock", register.name, strings.Join(loads, " and "), strings.Join(increments, " and "))
+		}
+	}
+	for _, reserved := range reservedALUModes {
+		if mode == reserved {
+			problemf("reserved ALU mode %s", mode)
+		}
+	}
+}
+
+// signals returns the names of the signals of set that word has
+func signals(word Control, set []Control) []string {
+	var names []string
+	for _, signal := range set {
+		if word&signal != 0 {
+			names = append(names, signal.String())
+		}
+	}
+	return names
+}
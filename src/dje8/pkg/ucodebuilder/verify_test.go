@@ -0,0 +1,62 @@
+package ucodebuilder_test
+
+import (
+	"testing"
+
+	//lint:ignore ST1001 importing common shared across all dje8 cmds
+	. "damien.live/dje8/pkg/common"
+	"damien.live/dje8/pkg/ucodebuilder"
+)
+
+func TestVerifyMicrocode(t *testing.T) {
+	for _, problem := range ucodebuilder.Verify(ucodebuilder.BuildUcode()) {
+		t.Error(problem)
+	}
+}
+
+// verifyCases each replace one step of an instruction, for every value of
+// the flags, with a bad control word and give the problem Verify reports
+var verifyCases = []struct {
+	Name string
+	Op   OpCode
+	Step int
+	Word Control
+	Want string
+}{
+	{"two data bus drivers", LODA, 4, AO | RO | AI | STR, "LODA step 4 (any flags): data bus driven by AO and RO"},
+	{"ALU and data bus driver", LODA, 4, RO | ucodebuilder.ALU(ALUADD) | AI | STR, "LODA step 4 (any flags): data bus driven by RO and ALUADD"},
+	{"two address bus drivers", JMP, 3, COW | ROW | CIW | STR, "JMP step 3 (any flags): address bus driven by COW and ROW"},
+	{"undriven data bus", LODA, 4, AI | STR, "LODA step 4 (any flags): nothing drives the data bus read by AI"},
+	{"undriven address bus", JMP, 3, CIW | STR, "JMP step 3 (any flags): nothing drives the address bus read by CIW"},
+	{"RI before MIW", STOA, 2, AO | RI | STR, "STOA step 2 (any flags): memory written by RI before MIW has loaded the Memory Address Register"},
+	{"conflicting increments", PUSH, 2, PD | PU, "PUSH step 2 (any flags): conflicting increments PU and PD"},
+	{"load and increment", JMP, 3, ROW | CIW | CU | STR, "JMP step 3 (any flags): PC loaded by CIW and incremented by CU on the same clock"},
+	{"reserved ALU mode", NOT, 2, ucodebuilder.ALU(ALUX2_) | AI | STR, "NOT step 2 (any flags): reserved ALU mode ALUX2_"},
+	{"no STR", LODI, 3, RO | AI | CU, "LODI step 15 (any flags): no STR or HLT within 16 steps"},
+}
+
+func TestVerify(t *testing.T) {
+	for _, c := range verifyCases {
+		t.Run(c.Name, func(t *testing.T) {
+			ucode := ucodebuilder.BuildUcode()
+			for flags := range 16 {
+				ucode[flags<<12|int(c.Op)<<4|c.Step] = c.Word
+			}
+			problems := ucodebuilder.Verify(ucode)
+			if len(problems) != 1 || problems[0].Error() != c.Want {
+				t.Errorf("got %v, want %s", problems, c.Want)
+			}
+		})
+	}
+}
+
+func TestVerifyFlags(t *testing.T) {
+	ucode := ucodebuilder.BuildUcode()
+	for _, flags := range []int{0, 1, 8, 9} { // Z and V clear or set, C and N clear
+		ucode[flags<<12|int(LODA)<<4|4] = AI | STR
+	}
+	want := "LODA step 4 (no flags, V, Z, ZV): nothing drives the data bus read by AI"
+	if problems := ucodebuilder.Verify(ucode); len(problems) != 1 || problems[0].Error() != want {
+		t.Errorf("got %v, want %s", problems, want)
+	}
+}